@@ -82,11 +82,11 @@ func tlsDialWithDialer(dialer *net.Dialer, network, addr string, config *tls.Con
 		tcpConn.SetKeepAlivePeriod(30 * time.Second)
 	}
 
-	colonPos := strings.LastIndex(addr, ":")
-	if colonPos == -1 {
-		colonPos = len(addr)
+	// SplitHostPort also strips the brackets of IPv6 addresses
+	hostname, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		hostname = addr
 	}
-	hostname := addr[:colonPos]
 
 	// If no ServerName is set, infer the ServerName
 	// from the hostname we're connecting to.
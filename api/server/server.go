@@ -16,9 +16,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
-
-	"crypto/tls"
-	"crypto/x509"
+	"time"
 
 	"code.google.com/p/go.net/websocket"
 	"github.com/docker/libcontainer/user"
@@ -37,6 +35,10 @@ import (
 	"github.com/docker/docker/utils"
 )
 
+// defaultBufferRequestsMax is the number of connections held by a buffering
+// listener before the daemon accepts them, unless BufferRequestsMax is set.
+const defaultBufferRequestsMax = 1024
+
 var (
 	activationLock chan struct{}
 )
@@ -1427,39 +1429,12 @@ func lookupGidByName(nameOrGid string) (int, error) {
 	return -1, fmt.Errorf("Group %s not found", nameOrGid)
 }
 
-func setupTls(cert, key, ca string, l net.Listener) (net.Listener, error) {
-	tlsCert, err := tls.LoadX509KeyPair(cert, key)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("Could not load X509 key pair (%s, %s): %v", cert, key, err)
-		}
-		return nil, fmt.Errorf("Error reading X509 key pair (%s, %s): %q. Make sure the key is encrypted.",
-			cert, key, err)
-	}
-	tlsConfig := &tls.Config{
-		NextProtos:   []string{"http/1.1"},
-		Certificates: []tls.Certificate{tlsCert},
-		// Avoid fallback on insecure SSL protocols
-		MinVersion: tls.VersionTLS10,
-	}
-
-	if ca != "" {
-		certPool := x509.NewCertPool()
-		file, err := ioutil.ReadFile(ca)
-		if err != nil {
-			return nil, fmt.Errorf("Could not read CA certificate: %v", err)
-		}
-		certPool.AppendCertsFromPEM(file)
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		tlsConfig.ClientCAs = certPool
-	}
-
-	return tls.NewListener(l, tlsConfig), nil
-}
-
-func newListener(proto, addr string, bufferRequests bool) (net.Listener, error) {
+func newListener(proto, addr string, bufferRequests bool, maxBuffered int) (net.Listener, error) {
 	if bufferRequests {
-		return listenbuffer.NewListenBuffer(proto, addr, activationLock)
+		if maxBuffered <= 0 {
+			maxBuffered = defaultBufferRequestsMax
+		}
+		return listenbuffer.NewListenBuffer(proto, addr, activationLock, maxBuffered)
 	}
 
 	return net.Listen(proto, addr)
@@ -1521,9 +1496,14 @@ func setupTcpHttp(addr string, job *engine.Job) (*HttpServer, error) {
 		log.Infof("/!\\ DON'T BIND ON ANY IP ADDRESS WITHOUT setting -tlsverify IF YOU DON'T KNOW WHAT YOU'RE DOING /!\\")
 	}
 
+	cipherSuites, err := parseCipherSuites(job.GetenvList("TlsCipherSuites"))
+	if err != nil {
+		return nil, err
+	}
+
 	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"))
 
-	l, err := newListener("tcp", addr, job.GetenvBool("BufferRequests"))
+	l, err := newListener("tcp", addr, job.GetenvBool("BufferRequests"), job.GetenvInt("BufferRequestsMax"))
 	if err != nil {
 		return nil, err
 	}
@@ -1537,7 +1517,7 @@ func setupTcpHttp(addr string, job *engine.Job) (*HttpServer, error) {
 		if job.GetenvBool("TlsVerify") {
 			tlsCa = job.Getenv("TlsCa")
 		}
-		l, err = setupTls(job.Getenv("TlsCert"), job.Getenv("TlsKey"), tlsCa, l)
+		l, err = setupTls(job.Getenv("TlsCert"), job.Getenv("TlsKey"), tlsCa, cipherSuites, l)
 		if err != nil {
 			return nil, err
 		}
@@ -1552,12 +1532,21 @@ type Server interface {
 
 // ServeApi loops through all of the protocols sent in to docker and spawns
 // off a go routine to setup a serving http.Server for each.
+//
+// Once every listener has been bound, or has failed to, and before any
+// request is served, "ready" is written to the job's stdout so supervisors
+// can tell when the api is up. If StartupTimeout (in seconds) is set and the
+// listeners are not all set up within it, the job fails.
+//
+// A listener which fails is logged and the others keep serving; the job only
+// fails if every listener failed.
 func ServeApi(job *engine.Job) engine.Status {
 	if len(job.Args) == 0 {
 		return job.Errorf("usage: %s PROTO://ADDR [PROTO://ADDR ...]", job.Name)
 	}
 	var (
 		protoAddrs = job.Args
+		chStarted  = make(chan error, len(protoAddrs))
 		chErrors   = make(chan error, len(protoAddrs))
 	)
 	activationLock = make(chan struct{})
@@ -1571,19 +1560,62 @@ func ServeApi(job *engine.Job) engine.Status {
 			log.Infof("Listening for HTTP on %s (%s)", protoAddrParts[0], protoAddrParts[1])
 			srv, err := NewServer(protoAddrParts[0], protoAddrParts[1], job)
 			if err != nil {
-				chErrors <- err
+				chStarted <- fmt.Errorf("Could not listen on %s://%s: %v", protoAddrParts[0], protoAddrParts[1], err)
+				return
+			}
+			chStarted <- nil
+			if err := srv.Serve(); err != nil {
+				chErrors <- fmt.Errorf("Stopped serving HTTP on %s://%s: %v", protoAddrParts[0], protoAddrParts[1], err)
 				return
 			}
-			chErrors <- srv.Serve()
+			chErrors <- nil
 		}()
 	}
 
-	for i := 0; i < len(protoAddrs); i++ {
-		err := <-chErrors
-		if err != nil {
-			return job.Error(err)
+	var timeout <-chan time.Time
+	if secs := job.GetenvInt("StartupTimeout"); secs > 0 {
+		timeout = time.After(time.Duration(secs) * time.Second)
+	}
+
+	var (
+		starting = len(protoAddrs)
+		running  int
+		failed   int
+		lastErr  error
+	)
+	for starting > 0 || running > 0 {
+		select {
+		case err := <-chStarted:
+			starting--
+			if err != nil {
+				log.Error(err)
+				failed++
+				lastErr = err
+			} else {
+				running++
+			}
+			if starting == 0 {
+				if running == 0 {
+					return job.Error(lastErr)
+				}
+				timeout = nil
+				log.Debugf("All API listeners set up, %d of %d bound", running, len(protoAddrs))
+				fmt.Fprintln(job.Stdout, "ready")
+			}
+		case err := <-chErrors:
+			running--
+			if err != nil {
+				log.Error(err)
+				failed++
+				lastErr = err
+			}
+		case <-timeout:
+			return job.Errorf("timed out waiting for the API listeners to be bound")
 		}
 	}
+	if failed == len(protoAddrs) {
+		return job.Error(lastErr)
+	}
 
 	return engine.StatusOK
 }
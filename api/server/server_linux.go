@@ -4,6 +4,7 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"syscall"
@@ -17,7 +18,7 @@ func NewServer(proto, addr string, job *engine.Job) (Server, error) {
 	// Basic error and sanity checking
 	switch proto {
 	case "fd":
-		return nil, serveFd(addr, job)
+		return setupFdHttp(addr, job)
 	case "tcp":
 		return setupTcpHttp(addr, job)
 	case "unix":
@@ -36,7 +37,7 @@ func setupUnixHttp(addr string, job *engine.Job) (*HttpServer, error) {
 	mask := syscall.Umask(0777)
 	defer syscall.Umask(mask)
 
-	l, err := newListener("unix", addr, job.GetenvBool("BufferRequests"))
+	l, err := newListener("unix", addr, job.GetenvBool("BufferRequests"), job.GetenvInt("BufferRequestsMax"))
 	if err != nil {
 		return nil, err
 	}
@@ -52,17 +53,17 @@ func setupUnixHttp(addr string, job *engine.Job) (*HttpServer, error) {
 	return &HttpServer{&http.Server{Addr: addr, Handler: r}, l}, nil
 }
 
-// serveFd creates an http.Server and sets it up to serve given a socket activated
-// argument.
-func serveFd(addr string, job *engine.Job) error {
-	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"))
-
-	ls, e := systemd.ListenFD(addr)
-	if e != nil {
-		return e
-	}
+// FdServer serves the http api on the sockets passed in by systemd socket
+// activation.
+type FdServer struct {
+	srv *http.Server
+	ls  []net.Listener
+}
 
-	chErrors := make(chan error, len(ls))
+// Serve waits for the daemon to accept connections and then serves on every
+// socket activated listener, returning the first error encountered.
+func (s *FdServer) Serve() error {
+	chErrors := make(chan error, len(s.ls))
 
 	// We don't want to start serving on these sockets until the
 	// daemon is initialized and installed. Otherwise required handlers
@@ -71,15 +72,14 @@ func serveFd(addr string, job *engine.Job) error {
 
 	// Since ListenFD will return one or more sockets we have
 	// to create a go func to spawn off multiple serves
-	for i := range ls {
-		listener := ls[i]
+	for i := range s.ls {
+		listener := s.ls[i]
 		go func() {
-			httpSrv := http.Server{Handler: r}
-			chErrors <- httpSrv.Serve(listener)
+			chErrors <- s.srv.Serve(listener)
 		}()
 	}
 
-	for i := 0; i < len(ls); i++ {
+	for i := 0; i < len(s.ls); i++ {
 		err := <-chErrors
 		if err != nil {
 			return err
@@ -89,6 +89,29 @@ func serveFd(addr string, job *engine.Job) error {
 	return nil
 }
 
+func (s *FdServer) Close() error {
+	var firstErr error
+	for _, l := range s.ls {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// setupFdHttp creates an http.Server and sets it up to serve given a socket activated
+// argument.
+func setupFdHttp(addr string, job *engine.Job) (*FdServer, error) {
+	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"))
+
+	ls, err := systemd.ListenFD(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FdServer{&http.Server{Handler: r}, ls}, nil
+}
+
 // Called through eng.Job("acceptconnections")
 func AcceptConnections(job *engine.Job) engine.Status {
 	// Tell the init daemon we are accepting requests
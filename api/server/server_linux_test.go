@@ -0,0 +1,147 @@
+// +build linux
+
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestServeApiReady(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-serveapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	addr := filepath.Join(tmp, "docker.sock")
+
+	eng := engine.New()
+	if err := eng.Register("serveapi", ServeApi); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("serveapi", "unix://"+addr)
+	stdout, err := job.Stdout.AddPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- job.Run()
+	}()
+
+	chReady := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		chReady <- line
+	}()
+
+	select {
+	case line := <-chReady:
+		if line != "ready\n" {
+			t.Fatalf("Expected ready notification, got %q", line)
+		}
+	case err := <-chErr:
+		t.Fatalf("serveapi exited before becoming ready: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the api to become ready")
+	}
+
+	// The listener must be bound by the time readiness is reported
+	if _, err := os.Stat(addr); err != nil {
+		t.Fatalf("Expected socket to be bound when ready: %v", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", addr)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestServeApiStartupError(t *testing.T) {
+	eng := engine.New()
+	if err := eng.Register("serveapi", ServeApi); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("serveapi", "bogus://addr")
+	job.SetenvInt("StartupTimeout", 5)
+	if err := job.Run(); err == nil {
+		t.Fatal("Expected serveapi to fail on an invalid protocol")
+	}
+}
+
+func TestServeApiOneListenerFails(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-serveapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	addr := filepath.Join(tmp, "docker.sock")
+
+	eng := engine.New()
+	if err := eng.Register("serveapi", ServeApi); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("serveapi", "bogus://addr", "unix://"+addr)
+	stdout, err := job.Stdout.AddPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- job.Run()
+	}()
+
+	chReady := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		chReady <- line
+	}()
+
+	select {
+	case line := <-chReady:
+		if line != "ready\n" {
+			t.Fatalf("Expected ready notification, got %q", line)
+		}
+	case err := <-chErr:
+		t.Fatalf("serveapi exited although a listener was bound: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the api to become ready")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", addr)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
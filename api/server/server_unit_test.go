@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -551,3 +553,32 @@ var sampleImage getImagesJSONStruct = getImagesJSONStruct{
 	Size:        777,
 	VirtualSize: 666,
 }
+
+func TestSetupTcpHttpIPv6(t *testing.T) {
+	if l, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	} else {
+		l.Close()
+	}
+
+	eng := engine.New()
+	srv, err := setupTcpHttp("[::1]:0", eng.Job("serveapi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve()
+
+	resp, err := http.Get("http://" + srv.l.Addr().String() + "/_ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+		t.Fatalf("expected OK from the api on %s, got %d %q", srv.l.Addr(), resp.StatusCode, body)
+	}
+}
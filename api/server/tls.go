@@ -0,0 +1,176 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/engine"
+)
+
+var (
+	tlsListenersLock sync.Mutex
+	tlsListeners     = make(map[*tlsListener]struct{})
+)
+
+// tlsListener serves TLS with a config which can be reloaded from disk while
+// it is running. Each accepted connection uses the config current at the
+// time, so established connections keep the certificates they started with.
+type tlsListener struct {
+	net.Listener
+	cert, key, ca string
+	cipherSuites  []uint16
+	config        atomic.Value // *tls.Config
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(c, l.config.Load().(*tls.Config)), nil
+}
+
+func (l *tlsListener) Close() error {
+	tlsListenersLock.Lock()
+	delete(tlsListeners, l)
+	tlsListenersLock.Unlock()
+	return l.Listener.Close()
+}
+
+// reload reads the certificates again, the current config is kept if they
+// cannot be loaded.
+func (l *tlsListener) reload() error {
+	config, err := loadTlsConfig(l.cert, l.key, l.ca, l.cipherSuites)
+	if err != nil {
+		return err
+	}
+	l.config.Store(config)
+	return nil
+}
+
+// tlsCipherSuites maps the names of the cipher suites which can be enabled on
+// the TLS listeners to their crypto/tls values. The RC4 and 3DES suites are
+// left out as they are too weak.
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// defaultCipherSuites are the suites used when none are given, every allowed
+// suite in order of preference.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// parseCipherSuites returns the values of the named cipher suites. An empty
+// list gives the default suites.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites, nil
+	}
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, exists := tlsCipherSuites[name]
+		if !exists {
+			return nil, fmt.Errorf("Unknown TLS cipher suite: %s", name)
+		}
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
+func setupTls(cert, key, ca string, cipherSuites []uint16, l net.Listener) (net.Listener, error) {
+	tl := &tlsListener{
+		Listener:     l,
+		cert:         cert,
+		key:          key,
+		ca:           ca,
+		cipherSuites: cipherSuites,
+	}
+	if err := tl.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsListenersLock.Lock()
+	tlsListeners[tl] = struct{}{}
+	tlsListenersLock.Unlock()
+	return tl, nil
+}
+
+func loadTlsConfig(cert, key, ca string, cipherSuites []uint16) (*tls.Config, error) {
+	tlsCert, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Could not load X509 key pair (%s, %s): %v", cert, key, err)
+		}
+		return nil, fmt.Errorf("Error reading X509 key pair (%s, %s): %q. Make sure the key is encrypted.",
+			cert, key, err)
+	}
+	tlsConfig := &tls.Config{
+		NextProtos:   []string{"http/1.1"},
+		Certificates: []tls.Certificate{tlsCert},
+		// Avoid fallback on insecure SSL protocols
+		MinVersion:   tls.VersionTLS10,
+		CipherSuites: cipherSuites,
+	}
+
+	if ca != "" {
+		certPool := x509.NewCertPool()
+		file, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA certificate: %v", err)
+		}
+		certPool.AppendCertsFromPEM(file)
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = certPool
+	}
+
+	return tlsConfig, nil
+}
+
+// ReloadTls reads the certificate, key and CA of every TLS listener of the
+// api from disk again. New connections use them once they are reloaded.
+// It fails when the api has no TLS listener to reload.
+//
+// Called through eng.Job("reloadtls")
+func ReloadTls(job *engine.Job) engine.Status {
+	tlsListenersLock.Lock()
+	defer tlsListenersLock.Unlock()
+
+	if len(tlsListeners) == 0 {
+		return job.Errorf("No TLS listener to reload: the API is not served over TLS")
+	}
+
+	var errors []string
+	for l := range tlsListeners {
+		if err := l.reload(); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	if len(errors) > 0 {
+		return job.Errorf("Could not reload TLS certificates: %s", strings.Join(errors, ", "))
+	}
+	log.Infof("Reloaded the TLS certificates of %d API listeners", len(tlsListeners))
+	return engine.StatusOK
+}
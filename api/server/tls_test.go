@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/engine"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := parseCipherSuites(nil)
+	if err != nil || !reflect.DeepEqual(suites, defaultCipherSuites) {
+		t.Fatalf("expected the default cipher suites, got %v (%v)", suites, err)
+	}
+	if len(defaultCipherSuites) != len(tlsCipherSuites) {
+		t.Fatalf("expected every allowed cipher suite to be used by default")
+	}
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"} {
+		if _, err := parseCipherSuites([]string{name}); err == nil {
+			t.Fatalf("expected the weak cipher suite %s to be rejected", name)
+		}
+	}
+
+	suites, err = parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_AES_256_CBC_SHA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_256_CBC_SHA}
+	if !reflect.DeepEqual(suites, expected) {
+		t.Fatalf("expected %v, got %v", expected, suites)
+	}
+
+	if _, err := parseCipherSuites([]string{"TLS_RSA_WITH_AES_128_CBC_SHA", "TLS_BOGUS"}); err == nil || !strings.Contains(err.Error(), "TLS_BOGUS") {
+		t.Fatalf("expected an error naming the unknown suite, got %v", err)
+	}
+}
+
+// writeKeyPair writes a self-signed certificate with the given serial number
+// and its key to cert and key.
+func writeKeyPair(t *testing.T, cert, key string, serial int64) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadTlsWithoutListeners(t *testing.T) {
+	eng := engine.New()
+	if err := eng.Register("reloadtls", ReloadTls); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("reloadtls").Run(); err == nil {
+		t.Fatal("expected reloading without a TLS listener to fail")
+	}
+}
+
+func TestReloadTls(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cert := filepath.Join(tmp, "cert.pem")
+	key := filepath.Join(tmp, "key.pem")
+	writeKeyPair(t, cert, key, 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err = setupTls(cert, key, "", nil, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go c.(*tls.Conn).Handshake()
+		}
+	}()
+
+	serial := func() (*tls.Conn, int64) {
+		c, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, c.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	before, s := serial()
+	defer before.Close()
+	if s != 1 {
+		t.Fatalf("expected certificate 1, got %d", s)
+	}
+
+	eng := engine.New()
+	if err := eng.Register("reloadtls", ReloadTls); err != nil {
+		t.Fatal(err)
+	}
+
+	// a broken key pair is reported and the current one is kept
+	if err := ioutil.WriteFile(key, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("reloadtls").Run(); err == nil {
+		t.Fatal("expected reloading an invalid key pair to fail")
+	}
+	after, s := serial()
+	after.Close()
+	if s != 1 {
+		t.Fatalf("expected certificate 1 to be kept, got %d", s)
+	}
+
+	writeKeyPair(t, cert, key, 2)
+	if err := eng.Job("reloadtls").Run(); err != nil {
+		t.Fatal(err)
+	}
+	after, s = serial()
+	after.Close()
+	if s != 2 {
+		t.Fatalf("expected certificate 2 after reload, got %d", s)
+	}
+	if s := before.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); s != 1 {
+		t.Fatalf("expected the established connection to keep certificate 1, got %d", s)
+	}
+}
@@ -34,7 +34,10 @@ func remote(eng *engine.Engine) error {
 	if err := eng.Register("serveapi", apiserver.ServeApi); err != nil {
 		return err
 	}
-	return eng.Register("acceptconnections", apiserver.AcceptConnections)
+	if err := eng.Register("acceptconnections", apiserver.AcceptConnections); err != nil {
+		return err
+	}
+	return eng.Register("reloadtls", apiserver.ReloadTls)
 }
 
 // daemon: a default execution and storage backend for Docker on Linux,
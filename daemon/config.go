@@ -13,6 +13,7 @@ import (
 const (
 	defaultNetworkMtu    = 1500
 	disableNetworkBridge = "none"
+	defaultMaxVolumes    = 1000
 )
 
 // Config define the configuration of a docker daemon
@@ -49,6 +50,8 @@ type Config struct {
 	Labels                      []string
 	Ulimits                     map[string]*ulimit.Ulimit
 	LogConfig                   runconfig.LogConfig
+	MaxVolumes                  int
+	TlsCipherSuites             string
 }
 
 // InstallFlags adds command-line options to the top-level flag parser for
@@ -72,9 +75,11 @@ func (config *Config) InstallFlags() {
 	flag.StringVar(&config.ExecDriver, []string{"e", "-exec-driver"}, "native", "Exec driver to use")
 	flag.BoolVar(&config.EnableSelinuxSupport, []string{"-selinux-enabled"}, false, "Enable selinux support")
 	flag.IntVar(&config.Mtu, []string{"#mtu", "-mtu"}, 0, "Set the containers network MTU")
+	flag.IntVar(&config.MaxVolumes, []string{"-max-volumes"}, defaultMaxVolumes, "Maximum number of volumes per container")
 	flag.StringVar(&config.SocketGroup, []string{"G", "-group"}, "docker", "Group for the unix socket")
 	flag.BoolVar(&config.EnableCors, []string{"#api-enable-cors", "#-api-enable-cors"}, false, "Enable CORS headers in the remote API, this is deprecated by --api-cors-header")
 	flag.StringVar(&config.CorsHeaders, []string{"-api-cors-header"}, "", "Set CORS headers in the remote API")
+	flag.StringVar(&config.TlsCipherSuites, []string{"-tlsciphers"}, "", "Comma separated list of TLS cipher suites to allow")
 	opts.IPVar(&config.DefaultIp, []string{"#ip", "-ip"}, "0.0.0.0", "Default IP when binding container ports")
 	opts.ListVar(&config.GraphOptions, []string{"-storage-opt"}, "Set storage driver options")
 	// FIXME: why the inconsistency between "hosts" and "sockets"?
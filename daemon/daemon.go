@@ -140,6 +140,10 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"execStart":         daemon.ContainerExecStart,
 		"execResize":        daemon.ContainerExecResize,
 		"execInspect":       daemon.ContainerExecInspect,
+		"volumes":           daemon.Volumes,
+		"volumes_prune":     daemon.VolumesPrune,
+		"volumes_inspect":   daemon.VolumesInspect,
+		"volumes_snapshot":  daemon.VolumesSnapshot,
 	} {
 		if err := eng.Register(name, method); err != nil {
 			return err
@@ -402,6 +406,10 @@ func (daemon *Daemon) restore() error {
 		registeredContainers = append(registeredContainers, container)
 	}
 
+	if err := daemon.reconcileVolumeReferences(); err != nil {
+		log.Errorf("Failed to reconcile volume references: %v", err)
+	}
+
 	// check the restart policy on the containers and restart any container with
 	// the restart policy of "always"
 	if daemon.config.AutoRestart {
@@ -427,6 +435,22 @@ func (daemon *Daemon) restore() error {
 	return nil
 }
 
+// reconcileVolumeReferences drops the volume references of containers which
+// no longer exist. Every container directory counts, so containers which
+// could not be loaded, or were created with another graph driver, keep theirs.
+func (daemon *Daemon) reconcileVolumeReferences() error {
+	dir, err := ioutil.ReadDir(daemon.repository)
+	if err != nil {
+		return err
+	}
+	liveIDs := make([]string, 0, len(dir))
+	for _, v := range dir {
+		liveIDs = append(liveIDs, v.Name())
+	}
+	daemon.volumes.ReconcileReferences(liveIDs)
+	return nil
+}
+
 // set up the watch on the host's /etc/resolv.conf so that we can update container's
 // live resolv.conf when the network changes on the host
 func (daemon *Daemon) setupResolvconfWatcher() error {
@@ -919,6 +943,7 @@ func NewDaemonFromDirectory(config *Config, eng *engine.Engine) (*Daemon, error)
 	if err != nil {
 		return nil, err
 	}
+	volumes.SetEventHandler(volumeEventLogger(eng))
 
 	trustKey, err := api.LoadOrCreateTrustKey(config.TrustKeyPath)
 	if err != nil {
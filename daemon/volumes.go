@@ -4,17 +4,21 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/execdriver"
+	"github.com/docker/docker/engine"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/docker/pkg/symlink"
 	"github.com/docker/docker/pkg/system"
 	"github.com/docker/docker/volumes"
+	"github.com/docker/libcontainer/label"
 )
 
 type Mount struct {
@@ -23,7 +27,14 @@ type Mount struct {
 	volume      *volumes.Volume
 	Writable    bool
 	copyData    bool
-	from        *Container
+	// copyStrategy tells how data is copied when copyData is set, it
+	// defaults to copySkipIfNonEmpty
+	copyStrategy copyStrategy
+	from         *Container
+	// relabel is the SELinux relabel option of a bind mount, "z" or "Z"
+	relabel string
+	// uid and gid are the numeric ids to give the root of a volume, if set
+	uid, gid string
 }
 
 func (mnt *Mount) Export(resource string) (io.ReadCloser, error) {
@@ -38,6 +49,21 @@ func (mnt *Mount) Export(resource string) (io.ReadCloser, error) {
 	return mnt.volume.Export(path, name)
 }
 
+// volumeEventLogger returns a handler sending volume lifecycle events to the
+// engine's event stream as "volume <action>". The event's "from" field holds
+// the container for mount and unmount events, and the volume driver otherwise.
+func volumeEventLogger(eng *engine.Engine) volumes.EventFunc {
+	return func(action string, v *volumes.Volume, containerID string) {
+		from := v.DriverName()
+		if containerID != "" {
+			from = containerID
+		}
+		if err := eng.Job("log", "volume "+action, v.ID, from).Run(); err != nil {
+			log.Errorf("Error logging event volume %s for %s: %s", action, v.ID, err)
+		}
+	}
+}
+
 func (container *Container) prepareVolumes() error {
 	if container.Volumes == nil || len(container.Volumes) == 0 {
 		container.Volumes = make(map[string]string)
@@ -59,6 +85,9 @@ func (container *Container) sortedVolumeMounts() []string {
 }
 
 func (container *Container) createVolumes() error {
+	if err := container.checkVolumeCount(); err != nil {
+		return err
+	}
 	mounts, err := container.parseVolumeMountConfig()
 	if err != nil {
 		return err
@@ -74,12 +103,62 @@ func (container *Container) createVolumes() error {
 	return container.applyVolumesFrom()
 }
 
+// checkVolumeCount makes sure the container doesn't ask for more volumes
+// than the daemon allows, before any of them is created. Volumes mounted at
+// the same path, including those from other containers, count once.
+func (container *Container) checkVolumeCount() error {
+	if container.daemon == nil || container.daemon.config == nil || container.daemon.config.MaxVolumes <= 0 {
+		return nil
+	}
+
+	paths := make(map[string]struct{})
+	for path := range container.Config.Volumes {
+		paths[filepath.Clean(path)] = struct{}{}
+	}
+	if container.hostConfig != nil {
+		for _, spec := range container.hostConfig.Binds {
+			// Invalid specs are reported when the volumes are parsed
+			if _, mountToPath, _, err := parseBindMountSpec(spec); err == nil {
+				paths[mountToPath] = struct{}{}
+			}
+		}
+		for _, spec := range container.hostConfig.VolumesFrom {
+			// Unknown containers are reported when the volumes are applied
+			id, _, _, err := parseVolumesFromSpec(spec)
+			if err != nil {
+				continue
+			}
+			c, err := container.daemon.Get(id)
+			if err != nil || c == nil {
+				continue
+			}
+			for path := range c.Volumes {
+				paths[path] = struct{}{}
+			}
+		}
+	}
+	for path := range container.Volumes {
+		paths[path] = struct{}{}
+	}
+
+	if max := container.daemon.config.MaxVolumes; len(paths) > max {
+		return fmt.Errorf("Container requests %d volumes, more than the maximum of %d allowed by the daemon", len(paths), max)
+	}
+	return nil
+}
+
 func (m *Mount) initialize() error {
+	if m.relabel != "" {
+		if err := label.Relabel(m.volume.Path, m.container.MountLabel, m.relabel); err != nil {
+			return err
+		}
+	}
+
 	// No need to initialize anything since it's already been initialized
 	if hostPath, exists := m.container.Volumes[m.MountToPath]; exists {
-		// If this is a bind-mount/volumes-from, maybe it was passed in at start instead of create
+		// If this is a bind-mount/named volume/volumes-from, maybe it was passed in at start instead of create
 		// We need to make sure bind-mounts/volumes-from passed on start can override existing ones.
-		if !m.volume.IsBindMount && m.from == nil {
+		if !m.volume.IsBindMount && m.volume.Name == "" && m.from == nil {
 			return nil
 		}
 		if m.volume.Path == hostPath {
@@ -97,19 +176,99 @@ func (m *Mount) initialize() error {
 	// This is the full path to container fs + mntToPath
 	containerMntPath, err := symlink.FollowSymlinkInScope(filepath.Join(m.container.basefs, m.MountToPath), m.container.basefs)
 	if err != nil {
-		return err
+		return fmt.Errorf("Cannot resolve volume mount point %s in container root %s: %v", m.MountToPath, m.container.basefs, err)
+	}
+	if m.volume.IsBindMount {
+		if err := checkMountTypes(m.volume.Path, containerMntPath, m.MountToPath); err != nil {
+			return err
+		}
 	}
 	m.container.VolumesRW[m.MountToPath] = m.Writable
 	m.container.Volumes[m.MountToPath] = m.volume.Path
 	m.volume.AddContainer(m.container.ID)
 	if m.Writable && m.copyData {
 		// Copy whatever is in the container at the mntToPath to the volume
-		copyExistingContents(containerMntPath, m.volume.Path)
+		copyExistingContents(containerMntPath, m.volume.Path, m.copyStrategy)
+	}
+	if m.uid != "" || m.gid != "" {
+		return chownVolume(m.volume.Path, m.uid, m.gid)
+	}
+
+	return nil
+}
+
+// chownVolume changes the owner of the root of a volume to the numeric uid
+// and gid, leaving either unchanged when empty.
+func chownVolume(path, uid, gid string) error {
+	ids := []int{-1, -1}
+	for i, id := range []string{uid, gid} {
+		if id == "" {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return err
+		}
+		ids[i] = n
 	}
+	return os.Lchown(path, ids[0], ids[1])
+}
 
+// checkMountTypes makes sure a bind mount source and its target in the
+// container are both directories or both files. Paths which don't exist yet
+// are not checked since they are created to match the source on mount.
+func checkMountTypes(source, target, mountToPath string) error {
+	sourceStat, err := os.Stat(source)
+	if err != nil {
+		return nil
+	}
+	targetStat, err := os.Stat(target)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case sourceStat.IsDir() && !targetStat.IsDir():
+		return fmt.Errorf("cannot mount directory %s onto file %s", source, mountToPath)
+	case !sourceStat.IsDir() && targetStat.IsDir():
+		return fmt.Errorf("cannot mount file %s onto directory %s", source, mountToPath)
+	}
 	return nil
 }
 
+// BackupContainerVolumes writes a single archive of all the volumes of the
+// container to w, see volumes.Backup. A running container is paused while its
+// volumes are archived so the backup is consistent across volumes.
+func (daemon *Daemon) BackupContainerVolumes(containerID string, w io.Writer) error {
+	container, err := daemon.Get(containerID)
+	if err != nil {
+		return err
+	}
+	return container.backupVolumes(w)
+}
+
+func (container *Container) backupVolumes(w io.Writer) error {
+	var vols []*volumes.Volume
+	for _, path := range container.sortedVolumeMounts() {
+		v := container.daemon.volumes.Get(container.Volumes[path])
+		if v == nil {
+			return fmt.Errorf("Volume %s of container %s does not exist", container.Volumes[path], container.ID)
+		}
+		vols = append(vols, v)
+	}
+
+	if container.IsRunning() && !container.IsPaused() {
+		if err := container.Pause(); err != nil {
+			return err
+		}
+		defer func() {
+			if err := container.Unpause(); err != nil {
+				log.Errorf("Error unpausing container %s after volume backup: %v", container.ID, err)
+			}
+		}()
+	}
+	return volumes.Backup(w, vols)
+}
+
 func (container *Container) VolumePaths() map[string]struct{} {
 	var paths = make(map[string]struct{})
 	for _, path := range container.Volumes {
@@ -121,7 +280,7 @@ func (container *Container) VolumePaths() map[string]struct{} {
 func (container *Container) registerVolumes() {
 	for path := range container.VolumePaths() {
 		if v := container.daemon.volumes.Get(path); v != nil {
-			v.AddContainer(container.ID)
+			v.RestoreContainer(container.ID)
 			continue
 		}
 
@@ -130,12 +289,12 @@ func (container *Container) registerVolumes() {
 		if rw, exists := container.VolumesRW[path]; exists {
 			writable = rw
 		}
-		v, err := container.daemon.volumes.FindOrCreateVolume(path, writable)
+		v, err := container.daemon.volumes.FindOrCreateVolume(path, writable, container.Config.Labels)
 		if err != nil {
 			log.Debugf("error registering volume %s: %v", path, err)
 			continue
 		}
-		v.AddContainer(container.ID)
+		v.RestoreContainer(container.ID)
 	}
 }
 
@@ -151,28 +310,53 @@ func (container *Container) derefVolumes() {
 }
 
 func (container *Container) parseVolumeMountConfig() (map[string]*Mount, error) {
-	var mounts = make(map[string]*Mount)
+	var (
+		mounts = make(map[string]*Mount)
+		binds  []string
+	)
+	// A container only partially loaded from disk may have no hostConfig
+	if container.hostConfig != nil {
+		binds = container.hostConfig.Binds
+	}
 	// Get all the bind mounts
-	for _, spec := range container.hostConfig.Binds {
-		path, mountToPath, writable, err := parseBindMountSpec(spec)
+	for _, spec := range binds {
+		path, mountToPath, mode, err := parseBindMountSpec(spec)
 		if err != nil {
 			return nil, err
 		}
+		// Exposing the volume configs would let containers corrupt them
+		if filepath.IsAbs(path) && container.daemon.volumes.IsConfigPath(path) {
+			return nil, fmt.Errorf("Invalid volume specification: %s: cannot bind mount the daemon's volume config directory", spec)
+		}
 		// Check if a bind mount has already been specified for the same container path
 		if m, exists := mounts[mountToPath]; exists {
 			return nil, fmt.Errorf("Duplicate volume %q: %q already in use, mounted from %q", path, mountToPath, m.volume.Path)
 		}
-		// Check if a volume already exists for this and use it
-		vol, err := container.daemon.volumes.FindOrCreateVolume(path, writable)
-		if err != nil {
-			return nil, err
-		}
-		mounts[mountToPath] = &Mount{
+
+		mnt := &Mount{
 			container:   container,
-			volume:      vol,
 			MountToPath: mountToPath,
-			Writable:    writable,
+			Writable:    mode.Writable,
+			relabel:     mode.Relabel,
+			uid:         mode.UID,
+			gid:         mode.GID,
 		}
+		if isVolumeName(path) {
+			// Named volumes get the image content like any other volume
+			mnt.volume, err = container.daemon.volumes.FindOrCreateNamedVolume(path, mode.Writable, container.Config.Labels)
+			mnt.copyData = !mode.NoCopy
+			mnt.copyStrategy = copySkipIfNonEmpty
+			if mode.Merge {
+				mnt.copyStrategy = copyAlwaysMerge
+			}
+		} else {
+			// Check if a volume already exists for this and use it
+			mnt.volume, err = container.daemon.volumes.FindOrCreateVolume(path, mode.Writable, container.Config.Labels)
+		}
+		if err != nil {
+			return nil, err
+		}
+		mounts[mountToPath] = mnt
 	}
 
 	// Get the rest of the volumes
@@ -190,30 +374,31 @@ func (container *Container) parseVolumeMountConfig() (map[string]*Mount, error)
 
 		if stat, err := os.Stat(filepath.Join(container.basefs, path)); err == nil {
 			if !stat.IsDir() {
-				return nil, fmt.Errorf("file exists at %s, can't create volume there")
+				return nil, fmt.Errorf("file exists at %s, can't create volume there", path)
 			}
 		}
 
-		vol, err := container.daemon.volumes.FindOrCreateVolume("", true)
+		vol, err := container.daemon.volumes.FindOrCreateVolume("", true, container.Config.Labels)
 		if err != nil {
 			return nil, err
 		}
 		mounts[path] = &Mount{
-			container:   container,
-			MountToPath: path,
-			volume:      vol,
-			Writable:    true,
-			copyData:    true,
+			container:    container,
+			MountToPath:  path,
+			volume:       vol,
+			Writable:     true,
+			copyData:     true,
+			copyStrategy: copySkipIfNonEmpty,
 		}
 	}
 
 	return mounts, nil
 }
 
-func parseBindMountSpec(spec string) (string, string, bool, error) {
+func parseBindMountSpec(spec string) (string, string, mountMode, error) {
 	var (
 		path, mountToPath string
-		writable          bool
+		mode              = mountMode{Writable: true}
 		arr               = strings.Split(spec, ":")
 	)
 
@@ -221,53 +406,191 @@ func parseBindMountSpec(spec string) (string, string, bool, error) {
 	case 2:
 		path = arr[0]
 		mountToPath = arr[1]
-		writable = true
 	case 3:
 		path = arr[0]
 		mountToPath = arr[1]
-		writable = validMountMode(arr[2]) && arr[2] == "rw"
+		var err error
+		if mode, err = parseMountMode(arr[2]); err != nil {
+			return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s: %v", spec, err)
+		}
+		// Binds always show the host's content, there is nothing to copy
+		if mode.NoCopy && !isVolumeName(path) {
+			return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s: nocopy only applies to volumes, not host binds", spec)
+		}
+		if mode.Merge && !isVolumeName(path) {
+			return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s: merge only applies to volumes, not host binds", spec)
+		}
+		if mode.Merge && mode.NoCopy {
+			return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s: merge and nocopy cannot be combined", spec)
+		}
+		// The ownership of host directories is left to the host
+		if (mode.UID != "" || mode.GID != "") && !isVolumeName(path) {
+			return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s: uid and gid only apply to volumes, not host binds", spec)
+		}
 	default:
-		return "", "", false, fmt.Errorf("Invalid volume specification: %s", spec)
+		return "", "", mountMode{}, fmt.Errorf("Invalid volume specification: %s", spec)
 	}
 
+	if isVolumeName(path) {
+		return path, filepath.Clean(mountToPath), mode, nil
+	}
 	if !filepath.IsAbs(path) {
-		return "", "", false, fmt.Errorf("cannot bind mount volume: %s volume paths must be absolute.", path)
+		return "", "", mountMode{}, fmt.Errorf("cannot bind mount volume: %s volume paths must be absolute.", path)
 	}
 
 	path = filepath.Clean(path)
 	mountToPath = filepath.Clean(mountToPath)
-	return path, mountToPath, writable, nil
+	return path, mountToPath, mode, nil
+}
+
+// isVolumeName returns whether the source of a bind mount specification is
+// the name of a volume rather than a host path.
+func isVolumeName(source string) bool {
+	return source != "" && !strings.Contains(source, "/")
+}
+
+// mountMode holds the options given in the mode of a mount specification.
+type mountMode struct {
+	Writable bool
+	// Relabel is the SELinux relabel option, "z" or "Z"
+	Relabel string
+	// NoCopy disables copying the image content at the mount point into
+	// a new volume
+	NoCopy bool
+	// Merge copies the image content at the mount point into the volume
+	// even if it is not empty, replacing the files of the same name
+	Merge bool
+	// UID and GID are the numeric ids to give the root of a volume, if
+	// not empty
+	UID, GID string
+}
+
+// String returns the mode in the syntax it is parsed from, always starting
+// with "rw" or "ro".
+func (m mountMode) String() string {
+	mode := "ro"
+	if m.Writable {
+		mode = "rw"
+	}
+	if m.Relabel != "" {
+		mode += "," + m.Relabel
+	}
+	if m.NoCopy {
+		mode += ",nocopy"
+	}
+	if m.Merge {
+		mode += ",merge"
+	}
+	if m.UID != "" {
+		mode += ",uid=" + m.UID
+	}
+	if m.GID != "" {
+		mode += ",gid=" + m.GID
+	}
+	return mode
 }
 
-func parseVolumesFromSpec(spec string) (string, string, error) {
+// parseMountMode parses the mode of a mount, a comma separated list holding
+// at most one of "rw" and "ro", at most one of the SELinux relabel options
+// "z" (content shared between containers) and "Z" (content private to the
+// container), "nocopy" to not copy the content of the image at the mount
+// point into a volume, "merge" to copy it even into a volume which is not
+// empty, and "uid=" and "gid=" to set the numeric owner of a volume. Mounts
+// are writable by default.
+func parseMountMode(spec string) (mountMode, error) {
+	var (
+		mode   = mountMode{Writable: true}
+		access string
+	)
+	for _, opt := range strings.Split(spec, ",") {
+		switch {
+		case validMountMode(opt) && access == "":
+			access = opt
+			mode.Writable = opt == "rw"
+		case (opt == "z" || opt == "Z") && mode.Relabel == "":
+			mode.Relabel = opt
+		case opt == "nocopy" && !mode.NoCopy:
+			mode.NoCopy = true
+		case opt == "merge" && !mode.Merge:
+			mode.Merge = true
+		case strings.HasPrefix(opt, "uid=") && mode.UID == "":
+			if mode.UID = strings.TrimPrefix(opt, "uid="); !isNumericID(mode.UID) {
+				return mountMode{}, fmt.Errorf("invalid uid %q", mode.UID)
+			}
+		case strings.HasPrefix(opt, "gid=") && mode.GID == "":
+			if mode.GID = strings.TrimPrefix(opt, "gid="); !isNumericID(mode.GID) {
+				return mountMode{}, fmt.Errorf("invalid gid %q", mode.GID)
+			}
+		default:
+			return mountMode{}, fmt.Errorf("invalid mode %q", spec)
+		}
+	}
+	return mode, nil
+}
+
+// isNumericID returns whether id is a valid numeric uid or gid.
+func isNumericID(id string) bool {
+	n, err := strconv.ParseUint(id, 10, 32)
+	return err == nil && n < math.MaxUint32
+}
+
+// parseVolumesFromSpec parses a volumes-from specification, a container
+// optionally followed by a mode holding at most one of "rw" and "ro", and
+// "copy" to copy the content of the image at each mount point into the
+// volume mounted there. It returns the container, whether the volumes are
+// mounted writable and whether content is copied.
+func parseVolumesFromSpec(spec string) (string, bool, bool, error) {
 	specParts := strings.SplitN(spec, ":", 2)
 	if len(specParts) == 0 {
-		return "", "", fmt.Errorf("malformed volumes-from specification: %s", spec)
+		return "", false, false, fmt.Errorf("malformed volumes-from specification: %s", spec)
 	}
 
 	var (
-		id   = specParts[0]
-		mode = "rw"
+		id       = specParts[0]
+		writable = true
+		copyData bool
+		access   string
 	)
 	if len(specParts) == 2 {
-		mode = specParts[1]
-		if !validMountMode(mode) {
-			return "", "", fmt.Errorf("invalid mode for volumes-from: %s", mode)
+		mode := specParts[1]
+		for _, opt := range strings.Split(mode, ",") {
+			switch {
+			case validMountMode(opt) && access == "":
+				access = opt
+				writable = opt == "rw"
+			case opt == "copy" && !copyData:
+				copyData = true
+			default:
+				return "", false, false, fmt.Errorf("invalid mode for volumes-from: %s", mode)
+			}
 		}
 	}
-	return id, mode, nil
+	return id, writable, copyData, nil
 }
 
 func (container *Container) applyVolumesFrom() error {
+	if container.hostConfig == nil {
+		return nil
+	}
 	volumesFrom := container.hostConfig.VolumesFrom
 	if len(volumesFrom) > 0 && container.AppliedVolumesFrom == nil {
 		container.AppliedVolumesFrom = make(map[string]struct{})
 	}
 
-	mountGroups := make(map[string][]*Mount)
+	var (
+		mountGroups = make(map[string][]*Mount)
+		// the source of the volume mounted at each path, to catch conflicts
+		binds   = make(map[string]string)
+		sources = make(map[string]volumeSource)
+	)
+	for _, spec := range container.hostConfig.Binds {
+		if path, mountToPath, _, err := parseBindMountSpec(spec); err == nil {
+			binds[mountToPath] = path
+		}
+	}
 
 	for _, spec := range volumesFrom {
-		id, mode, err := parseVolumesFromSpec(spec)
+		id, writable, copyData, err := parseVolumesFromSpec(spec)
 		if err != nil {
 			return err
 		}
@@ -287,7 +610,23 @@ func (container *Container) applyVolumesFrom() error {
 		)
 
 		for _, mnt := range fromMounts {
-			mnt.Writable = mnt.Writable && (mode == "rw")
+			if source, exists := binds[mnt.MountToPath]; exists {
+				return fmt.Errorf("Duplicate volume %q: mounted both from %q and from the volumes of container %q", mnt.MountToPath, source, id)
+			}
+			if other, exists := sources[mnt.MountToPath]; exists {
+				// Containers sharing the same volume, e.g. through
+				// volumes-from themselves, don't conflict
+				if other.path == mnt.volume.Path {
+					continue
+				}
+				return fmt.Errorf("Duplicate volume %q: provided by the volumes of both containers %q and %q", mnt.MountToPath, other.id, id)
+			}
+			sources[mnt.MountToPath] = volumeSource{id: id, path: mnt.volume.Path}
+
+			mnt.Writable = mnt.Writable && writable
+			// Shared volumes are left alone unless a copy is asked for
+			mnt.copyData = copyData
+			mnt.copyStrategy = copySkipIfNonEmpty
 			mounts = append(mounts, mnt)
 		}
 		mountGroups[id] = mounts
@@ -306,6 +645,13 @@ func (container *Container) applyVolumesFrom() error {
 	return nil
 }
 
+// volumeSource is the container a volume is applied from and the path of the
+// volume.
+type volumeSource struct {
+	id   string
+	path string
+}
+
 func validMountMode(mode string) bool {
 	validModes := map[string]bool{
 		"rw": true,
@@ -359,32 +705,94 @@ func (container *Container) VolumeMounts() map[string]*Mount {
 	return mounts
 }
 
-func copyExistingContents(source, destination string) error {
+// VolumeModes returns the effective mode of each volume of the container,
+// keyed by the path it is mounted at: "rw" or "ro", followed by the other
+// options parseMountMode accepted for the bind mount providing it, if any.
+func (container *Container) VolumeModes() map[string]string {
+	binds := container.bindMountModes()
+	modes := make(map[string]string, len(container.Volumes))
+	for mountToPath := range container.Volumes {
+		mode := binds[mountToPath]
+		mode.Writable = container.VolumesRW[mountToPath]
+		modes[mountToPath] = mode.String()
+	}
+	return modes
+}
+
+// bindMountModes returns the modes of the container's bind mounts which are
+// actually mounted, keyed by the path they are mounted at.
+func (container *Container) bindMountModes() map[string]mountMode {
+	modes := make(map[string]mountMode)
+	if container.hostConfig == nil {
+		return modes
+	}
+	for _, spec := range container.hostConfig.Binds {
+		path, mountToPath, mode, err := parseBindMountSpec(spec)
+		if err != nil {
+			continue
+		}
+		if isVolumeName(path) {
+			if container.daemon == nil {
+				continue
+			}
+			v := container.daemon.volumes.GetByName(path)
+			if v == nil {
+				continue
+			}
+			path = v.Path
+		}
+		if hostPath, exists := container.Volumes[mountToPath]; exists && hostPath == path {
+			modes[mountToPath] = mode
+		}
+	}
+	return modes
+}
+
+// copyStrategy tells how the content of the image at the mount point of a
+// volume is copied into it.
+type copyStrategy string
+
+const (
+	// copySkipIfNonEmpty only copies into volumes which are empty
+	copySkipIfNonEmpty copyStrategy = "skip-if-nonempty"
+	// copyAlwaysMerge copies over the content of the volume, replacing
+	// the files which exist in both
+	copyAlwaysMerge copyStrategy = "always-merge"
+)
+
+func copyExistingContents(source, destination string, strategy copyStrategy) error {
 	volList, err := ioutil.ReadDir(source)
 	if err != nil {
 		return err
 	}
 
+	copied := false
 	if len(volList) > 0 {
 		srcList, err := ioutil.ReadDir(destination)
 		if err != nil {
 			return err
 		}
 
-		if len(srcList) == 0 {
+		if len(srcList) == 0 || strategy == copyAlwaysMerge {
 			// If the source volume is empty copy files from the root into the volume
 			if err := chrootarchive.CopyWithTar(source, destination); err != nil {
 				return err
 			}
+			copied = true
 		}
 	}
 
+	// The content of a volume which was not copied from the image belongs to
+	// the user, only its root takes the ownership of the image directory
+	if !copied {
+		return copyRootOwnership(source, destination)
+	}
 	return copyOwnership(source, destination)
 }
 
-// copyOwnership copies the permissions and uid:gid of the source file
+// copyRootOwnership copies the permissions and uid:gid of the source file
 // into the destination file
-func copyOwnership(source, destination string) error {
+func copyRootOwnership(source, destination string) error {
 	stat, err := system.Stat(source)
 	if err != nil {
 		return err
@@ -396,3 +804,46 @@ func copyOwnership(source, destination string) error {
 
 	return os.Chmod(destination, os.FileMode(stat.Mode()))
 }
+
+// copyOwnership copies the permissions and uid:gid of the source file
+// into the destination file, and of every file under the source directory
+// into the file at the same path under the destination, if any. Paths are
+// resolved within destination, so that symlinks left in the volume cannot
+// send the changes outside of it.
+func copyOwnership(source, destination string) error {
+	return filepath.Walk(source, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, srcPath)
+		if err != nil {
+			return err
+		}
+		// Only the last component is not followed, by Lstat and Lchown
+		parent, err := symlink.FollowSymlinkInScope(filepath.Join(destination, filepath.Dir(relPath)), destination)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(parent, filepath.Base(relPath))
+		dstInfo, err := os.Lstat(dstPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		stat, err := system.Lstat(srcPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(dstPath, int(stat.Uid()), int(stat.Gid())); err != nil {
+			return err
+		}
+		// Modes of symlinks can't be changed, chmod would follow them
+		if dstInfo.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chmod(dstPath, info.Mode())
+	})
+}
@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/volumes"
+)
+
+// VolumesInspect returns the metadata of a single volume, looked up by name,
+// ID (or unique ID prefix) or host path.
+func (daemon *Daemon) VolumesInspect(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("usage: %s NAME", job.Name)
+	}
+	v, err := daemon.lookupVolume(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+
+	out := &engine.Env{}
+	out.SetJson("Id", v.ID)
+	out.SetJson("Name", v.Name)
+	out.SetJson("Driver", v.DriverName())
+	out.SetJson("Path", v.Path)
+	out.SetBool("IsBindMount", v.IsBindMount)
+	out.SetBool("Writable", v.Writable)
+	meta := v.Metadata()
+	out.SetJson("Labels", meta.Labels)
+	out.SetTime("CreatedAt", meta.CreatedAt)
+	out.SetTime("LastUsedAt", meta.LastUsedAt)
+	out.SetList("Containers", v.Containers())
+	if _, err := out.WriteTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (daemon *Daemon) lookupVolume(ref string) (*volumes.Volume, error) {
+	if v := daemon.volumes.GetByName(ref); v != nil {
+		return v, nil
+	}
+	if v := daemon.volumes.GetByID(ref); v != nil {
+		return v, nil
+	}
+	if v := daemon.volumes.Get(ref); v != nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("No such volume: %s", ref)
+}
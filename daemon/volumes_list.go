@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/parsers/filters"
+)
+
+// Volumes lists the volumes known to the daemon, optionally filtered by
+// driver and by whether they are dangling, i.e. not used by any container.
+func (daemon *Daemon) Volumes(job *engine.Job) engine.Status {
+	volFilters, err := filters.FromParam(job.Getenv("filters"))
+	if err != nil {
+		return job.Error(err)
+	}
+	driver, inUse, err := parseVolumeFilters(volFilters)
+	if err != nil {
+		return job.Error(err)
+	}
+
+	outs := engine.NewTable("Path", 0)
+	for _, v := range daemon.volumes.Filter(driver, inUse) {
+		out := &engine.Env{}
+		out.SetJson("Id", v.ID)
+		out.SetJson("Path", v.Path)
+		out.SetJson("Driver", v.DriverName())
+		out.SetBool("IsBindMount", v.IsBindMount)
+		out.SetBool("Writable", v.Writable)
+		out.SetInt("Containers", len(v.Containers()))
+		meta := v.Metadata()
+		out.SetTime("CreatedAt", meta.CreatedAt)
+		out.SetTime("LastUsedAt", meta.LastUsedAt)
+		outs.Add(out)
+	}
+	outs.Sort()
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func parseVolumeFilters(volFilters filters.Args) (string, *bool, error) {
+	var (
+		driver string
+		inUse  *bool
+	)
+	for name, values := range volFilters {
+		if len(values) > 1 {
+			return "", nil, fmt.Errorf("Only one %s filter is supported", name)
+		}
+		switch name {
+		case "driver":
+			driver = values[0]
+		case "dangling":
+			var used bool
+			switch strings.ToLower(values[0]) {
+			case "true", "1":
+				used = false
+			case "false", "0":
+				used = true
+			default:
+				return "", nil, fmt.Errorf("Invalid dangling filter value: %s", values[0])
+			}
+			inUse = &used
+		default:
+			return "", nil, fmt.Errorf("Invalid volume filter: %s", name)
+		}
+	}
+	return driver, inUse, nil
+}
@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// VolumesPrune removes all the volumes that are not used by any container,
+// except for the ones created too recently to be in use yet.
+func (daemon *Daemon) VolumesPrune(job *engine.Job) engine.Status {
+	removed, pruneErr := daemon.volumes.Prune()
+	outs := engine.NewTable("", 0)
+	for _, id := range removed {
+		out := &engine.Env{}
+		out.SetJson("Deleted", id)
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	if pruneErr != nil {
+		return job.Error(pruneErr)
+	}
+	return engine.StatusOK
+}
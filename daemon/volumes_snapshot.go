@@ -0,0 +1,23 @@
+package daemon
+
+import (
+	"github.com/docker/docker/engine"
+)
+
+// VolumesSnapshot creates a new volume named after its second argument as a
+// copy of the volume given by name, ID or path, and prints the new volume ID.
+func (daemon *Daemon) VolumesSnapshot(job *engine.Job) engine.Status {
+	if len(job.Args) != 2 {
+		return job.Errorf("usage: %s VOLUME NAME", job.Name)
+	}
+	src, err := daemon.lookupVolume(job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	v, err := daemon.volumes.Snapshot(src.ID, job.Args[1])
+	if err != nil {
+		return job.Error(err)
+	}
+	job.Printf("%s\n", v.ID)
+	return engine.StatusOK
+}
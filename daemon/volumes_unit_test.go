@@ -0,0 +1,1153 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/engine"
+	"github.com/docker/docker/pkg/parsers/filters"
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/docker/runconfig"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
+	"github.com/docker/docker/volumes"
+)
+
+func init() {
+	reexec.Init()
+}
+
+// newRepo returns a volume repository keeping its configs in root/volumes,
+// backed by a vfs driver in root/graph.
+func newRepo(root string) (*volumes.Repository, error) {
+	driver, err := graphdriver.GetDriver("vfs", filepath.Join(root, "graph"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return volumes.NewRepository(filepath.Join(root, "volumes"), driver)
+}
+
+func TestPrepareVolumesNilHostConfig(t *testing.T) {
+	container := &Container{
+		ID:     "1234",
+		Config: &runconfig.Config{},
+	}
+
+	if err := container.prepareVolumes(); err != nil {
+		t.Fatal(err)
+	}
+	if len(container.Volumes) != 0 {
+		t.Fatalf("expected no volumes, got %v", container.Volumes)
+	}
+}
+
+func TestParseVolumeFilters(t *testing.T) {
+	driver, inUse, err := parseVolumeFilters(filters.Args{"driver": {"vfs"}, "dangling": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver != "vfs" {
+		t.Fatalf("expected driver vfs, got %q", driver)
+	}
+	if inUse == nil || *inUse {
+		t.Fatalf("expected dangling=true to select volumes not in use")
+	}
+
+	if _, inUse, err = parseVolumeFilters(filters.Args{}); err != nil || inUse != nil {
+		t.Fatalf("expected no usage filter by default, got %v (%v)", inUse, err)
+	}
+
+	for _, args := range []filters.Args{
+		{"dangling": {"maybe"}},
+		{"driver": {"vfs", "host"}},
+		{"label": {"foo"}},
+	} {
+		if _, _, err := parseVolumeFilters(args); err == nil {
+			t.Fatalf("expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestCheckMountTypes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "dir")
+	file := filepath.Join(tmp, "file")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = checkMountTypes(dir, file, "/target")
+	if err == nil || err.Error() != "cannot mount directory "+dir+" onto file /target" {
+		t.Fatalf("expected directory onto file error, got %v", err)
+	}
+	err = checkMountTypes(file, dir, "/target")
+	if err == nil || err.Error() != "cannot mount file "+file+" onto directory /target" {
+		t.Fatalf("expected file onto directory error, got %v", err)
+	}
+
+	for _, paths := range [][2]string{{dir, dir}, {file, file}, {dir, filepath.Join(tmp, "missing")}} {
+		if err := checkMountTypes(paths[0], paths[1], "/target"); err != nil {
+			t.Fatalf("expected %s onto %s to be allowed, got %v", paths[0], paths[1], err)
+		}
+	}
+}
+
+func TestVolumeInspect(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.FindOrCreateNamedVolume("foo", true, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("1234")
+
+	daemon := &Daemon{volumes: repo}
+	eng := engine.New()
+	if err := eng.Register("volumes_inspect", daemon.VolumesInspect); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"foo", v.ID, v.ID[:12], v.Path} {
+		job := eng.Job("volumes_inspect", ref)
+		out, err := job.Stdout.AddEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err != nil {
+			t.Fatalf("inspecting %s: %v", ref, err)
+		}
+		if id := out.Get("Id"); id != v.ID {
+			t.Fatalf("expected %s to resolve to %s, got %s", ref, v.ID, id)
+		}
+		if name := out.Get("Name"); name != "foo" {
+			t.Fatalf("expected name foo, got %q", name)
+		}
+		if driver := out.Get("Driver"); driver != "vfs" {
+			t.Fatalf("expected driver vfs, got %q", driver)
+		}
+		if containers := out.GetList("Containers"); len(containers) != 1 || containers[0] != "1234" {
+			t.Fatalf("expected container 1234, got %v", containers)
+		}
+		var labels map[string]string
+		if err := out.GetJson("Labels", &labels); err != nil || labels["a"] != "b" {
+			t.Fatalf("expected labels to be returned, got %v (%v)", labels, err)
+		}
+	}
+
+	job := eng.Job("volumes_inspect", "bar")
+	if err := job.Run(); err == nil || !strings.Contains(err.Error(), "No such volume: bar") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestBackupContainerVolumes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &Container{
+		ID:      "1234",
+		State:   NewState(),
+		Volumes: make(map[string]string),
+		daemon:  &Daemon{volumes: repo},
+	}
+	var expected []string
+	for _, mountToPath := range []string{"/a", "/b"} {
+		v, err := repo.FindOrCreateVolume("", true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(v.Path, "file"), []byte(mountToPath), 0644); err != nil {
+			t.Fatal(err)
+		}
+		container.Volumes[mountToPath] = v.Path
+		expected = append(expected, v.ID+"/config.json", v.ID+"/data/", v.ID+"/data/file")
+	}
+
+	var buf bytes.Buffer
+	if err := container.backupVolumes(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected backup entries %v, got %v", expected, names)
+	}
+}
+
+func TestParseBindMountSpecModes(t *testing.T) {
+	valid := map[string]mountMode{
+		"/a:/b":      {Writable: true},
+		"/a:/b:rw":   {Writable: true},
+		"/a:/b:ro":   {Writable: false},
+		"/a:/b:z":    {Writable: true, Relabel: "z"},
+		"/a:/b:Z":    {Writable: true, Relabel: "Z"},
+		"/a:/b:rw,z": {Writable: true, Relabel: "z"},
+		"/a:/b:ro,Z": {Writable: false, Relabel: "Z"},
+		"/a:/b:Z,ro": {Writable: false, Relabel: "Z"},
+	}
+	for spec, expected := range valid {
+		path, mountToPath, mode, err := parseBindMountSpec(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if path != "/a" || mountToPath != "/b" {
+			t.Fatalf("%s: expected /a mounted at /b, got %s at %s", spec, path, mountToPath)
+		}
+		if mode != expected {
+			t.Fatalf("%s: expected mode %+v, got %+v", spec, expected, mode)
+		}
+	}
+
+	for _, spec := range []string{"/a:/b:", "/a:/b:rx", "/a:/b:ro,rw", "/a:/b:z,Z", "/a:/b:ro,", "/a:/b:rw,z,z", "/a:/b:nocopy", "/a:/b:ro,nocopy"} {
+		if _, _, _, err := parseBindMountSpec(spec); err == nil {
+			t.Fatalf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestParseBindMountSpecNamedVolume(t *testing.T) {
+	path, mountToPath, mode, err := parseBindMountSpec("myvol:/data/:ro,nocopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "myvol" || mountToPath != "/data" {
+		t.Fatalf("expected volume myvol mounted at /data, got %s at %s", path, mountToPath)
+	}
+	if mode != (mountMode{Writable: false, NoCopy: true}) {
+		t.Fatalf("expected a read-only nocopy mode, got %+v", mode)
+	}
+
+	for _, spec := range []string{"my/vol:/data", "./myvol:/data", ":/data"} {
+		if _, _, _, err := parseBindMountSpec(spec); err == nil {
+			t.Fatalf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestParseVolumeMountConfigNamedVolume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &Container{
+		ID:         "1234",
+		Config:     &runconfig.Config{},
+		hostConfig: &runconfig.HostConfig{Binds: []string{"myvol:/data", "other:/other:ro,nocopy", "merged:/merged:merge"}},
+		daemon:     &Daemon{volumes: repo},
+	}
+	mounts, err := container.parseVolumeMountConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, other := mounts["/data"], mounts["/other"]
+	if data == nil || other == nil {
+		t.Fatalf("expected mounts at /data and /other, got %v", mounts)
+	}
+	if data.volume != repo.GetByName("myvol") || data.volume.IsBindMount {
+		t.Fatalf("expected /data to use the named volume myvol, got %+v", data.volume)
+	}
+	if !data.Writable || !data.copyData {
+		t.Fatalf("expected /data to be writable and get the image content")
+	}
+	if other.volume != repo.GetByName("other") || other.Writable || other.copyData {
+		t.Fatalf("expected /other to be a read-only nocopy mount of volume other")
+	}
+
+	if data.copyStrategy != copySkipIfNonEmpty {
+		t.Fatalf("expected /data to only get the image content if empty, got %s", data.copyStrategy)
+	}
+	if merged := mounts["/merged"]; merged == nil || !merged.copyData || merged.copyStrategy != copyAlwaysMerge {
+		t.Fatalf("expected /merged to get the image content merged in, got %+v", merged)
+	}
+
+	container.hostConfig.Binds = []string{"-myvol:/data"}
+	if _, err := container.parseVolumeMountConfig(); err == nil {
+		t.Fatalf("expected invalid volume name to be rejected")
+	}
+}
+
+func TestParseVolumeMountConfigLabels(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &Container{
+		ID: "1234",
+		Config: &runconfig.Config{
+			Labels:  map[string]string{"owner": "team-x"},
+			Volumes: map[string]struct{}{"/anon": {}},
+		},
+		hostConfig: &runconfig.HostConfig{Binds: []string{"myvol:/data"}},
+		daemon:     &Daemon{volumes: repo},
+	}
+	mounts, err := container.parseVolumeMountConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/data", "/anon"} {
+		mnt := mounts[path]
+		if mnt == nil {
+			t.Fatalf("expected a mount at %s, got %v", path, mounts)
+		}
+		if labels := mnt.volume.Metadata().Labels; labels["owner"] != "team-x" {
+			t.Fatalf("expected the volume at %s to get the container labels, got %v", path, labels)
+		}
+	}
+
+	container.Config.Labels["owner"] = "team-y"
+	if labels := repo.GetByName("myvol").Metadata().Labels; labels["owner"] != "team-x" {
+		t.Fatalf("expected the volume labels to be copied, got %v", labels)
+	}
+}
+
+func TestParseMountModeNoCopy(t *testing.T) {
+	mode, err := parseMountMode("ro,nocopy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode.Writable || !mode.NoCopy {
+		t.Fatalf("expected a read-only nocopy mode, got %+v", mode)
+	}
+	if mode, _ = parseMountMode("rw"); mode.NoCopy {
+		t.Fatalf("expected content to be copied by default")
+	}
+	if _, err := parseMountMode("nocopy,nocopy"); err == nil {
+		t.Fatalf("expected duplicate nocopy to be rejected")
+	}
+}
+
+func TestParseMountModeMerge(t *testing.T) {
+	_, _, mode, err := parseBindMountSpec("myvol:/data:ro,merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode != (mountMode{Writable: false, Merge: true}) || mode.String() != "ro,merge" {
+		t.Fatalf("expected a read-only merge mode, got %+v", mode)
+	}
+
+	for _, spec := range []string{
+		"/host:/data:merge",
+		"myvol:/data:merge,nocopy",
+		"myvol:/data:merge,merge",
+	} {
+		if _, _, _, err := parseBindMountSpec(spec); err == nil {
+			t.Fatalf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestParseMountModeOwner(t *testing.T) {
+	_, _, mode, err := parseBindMountSpec("myvol:/data:ro,uid=1000,gid=50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode.UID != "1000" || mode.GID != "50" {
+		t.Fatalf("expected owner 1000:50, got %q:%q", mode.UID, mode.GID)
+	}
+	if mode.String() != "ro,uid=1000,gid=50" {
+		t.Fatalf("expected mode ro,uid=1000,gid=50, got %s", mode)
+	}
+
+	for _, spec := range []string{
+		"myvol:/data:uid=",
+		"myvol:/data:uid=app",
+		"myvol:/data:gid=-1",
+		"myvol:/data:uid=1,uid=2",
+		"myvol:/data:gid=4294967295",
+		"/host:/data:uid=1000",
+		"/host:/data:gid=1000",
+	} {
+		if _, _, _, err := parseBindMountSpec(spec); err == nil {
+			t.Fatalf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestChownVolume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chown(tmp, 10, 20); err != nil {
+		t.Skipf("Cannot change ownership: %v", err)
+	}
+
+	for _, owner := range []struct {
+		uid, gid                 string
+		expectedUID, expectedGID uint32
+	}{
+		{"1000", "", 1000, 20},
+		{"", "50", 1000, 50},
+		{"0", "0", 0, 0},
+	} {
+		if err := chownVolume(tmp, owner.uid, owner.gid); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != owner.expectedUID || stat.Gid != owner.expectedGID {
+			t.Fatalf("expected owner %d:%d, got %d:%d", owner.expectedUID, owner.expectedGID, stat.Uid, stat.Gid)
+		}
+	}
+}
+
+func TestVolumeModes(t *testing.T) {
+	container := &Container{
+		Volumes: map[string]string{
+			"/rw":    "/var/lib/docker/vfs/dir/1234",
+			"/ro":    "/host/ro",
+			"/label": "/host/label",
+		},
+		VolumesRW: map[string]bool{
+			"/rw":    true,
+			"/ro":    false,
+			"/label": true,
+		},
+		hostConfig: &runconfig.HostConfig{
+			Binds: []string{"/host/ro:/ro:ro", "/host/label:/label:Z", "/host/other:/other:z"},
+		},
+	}
+
+	expected := map[string]string{
+		"/rw":    "rw",
+		"/ro":    "ro",
+		"/label": "rw,Z",
+	}
+	if modes := container.VolumeModes(); !reflect.DeepEqual(modes, expected) {
+		t.Fatalf("expected volume modes %v, got %v", expected, modes)
+	}
+}
+
+func TestParseVolumeMountConfigRejectsConfigPath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(tmp, "volumes")
+
+	for _, path := range []string{configPath, filepath.Join(configPath, "1234")} {
+		container := &Container{
+			ID:         "1234",
+			Config:     &runconfig.Config{},
+			hostConfig: &runconfig.HostConfig{Binds: []string{path + ":/data"}},
+			daemon:     &Daemon{volumes: repo},
+		}
+		if _, err := container.parseVolumeMountConfig(); err == nil || !strings.Contains(err.Error(), "volume config directory") {
+			t.Fatalf("expected binding %s to be rejected, got %v", path, err)
+		}
+	}
+	if vols := repo.List(); len(vols) != 0 {
+		t.Fatalf("expected no volume to be created, got %d", len(vols))
+	}
+}
+
+func TestParseVolumeMountConfigNamedLikeConfigPath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a relative path "volumes" would now resolve to the config directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	container := &Container{
+		ID:         "1234",
+		Config:     &runconfig.Config{},
+		hostConfig: &runconfig.HostConfig{Binds: []string{"volumes:/data"}},
+		daemon:     &Daemon{volumes: repo},
+	}
+	if _, err := container.parseVolumeMountConfig(); err != nil {
+		t.Fatalf("expected the volume named volumes to be accepted, got %v", err)
+	}
+}
+
+func TestDeleteVolumesKeepsNamedVolumes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := repo.FindOrCreateNamedVolume("myvol", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := &Daemon{volumes: repo}
+	daemon.DeleteVolumes(map[string]struct{}{named.Path: {}, anonymous.Path: {}})
+	if repo.GetByName("myvol") == nil {
+		t.Fatal("expected the named volume to be kept")
+	}
+	if repo.Get(anonymous.Path) != nil {
+		t.Fatal("expected the anonymous volume to be deleted")
+	}
+}
+
+func TestCopyExistingContentsStrategies(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"a": "image a", "b": "image b"} {
+		if err := ioutil.WriteFile(filepath.Join(source, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for strategy, expected := range map[copyStrategy]map[string]string{
+		copySkipIfNonEmpty: {"a": "volume a", "c": "volume c"},
+		copyAlwaysMerge:    {"a": "image a", "b": "image b", "c": "volume c"},
+	} {
+		destination := filepath.Join(tmp, string(strategy))
+		if err := os.Mkdir(destination, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range map[string]string{"a": "volume a", "c": "volume c"} {
+			if err := ioutil.WriteFile(filepath.Join(destination, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := copyExistingContents(source, destination, strategy); err != nil {
+			t.Fatal(err)
+		}
+		files, err := ioutil.ReadDir(destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[string]string)
+		for _, f := range files {
+			content, err := ioutil.ReadFile(filepath.Join(destination, f.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			found[f.Name()] = string(content)
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatalf("%s: expected %v, got %v", strategy, expected, found)
+		}
+	}
+}
+
+func TestCopyOwnershipRecursive(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	destination := filepath.Join(tmp, "destination")
+	for _, root := range []string{source, destination} {
+		if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, "a", "b", "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(destination, "a", "volume-only"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]struct {
+		uid, gid int
+		mode     os.FileMode
+	}{
+		"":              {1000, 1000, 0750},
+		"a":             {1001, 1002, 0700},
+		"a/b":           {1003, 1003, 0711},
+		"a/b/file":      {1004, 1005, 0600},
+		"a/volume-only": {0, 0, 0644},
+	}
+	for path, owner := range expected {
+		if path == "a/volume-only" {
+			continue
+		}
+		if err := os.Chown(filepath.Join(source, path), owner.uid, owner.gid); err != nil {
+			t.Skipf("Cannot change ownership: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(source, path), owner.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := copyOwnership(source, destination); err != nil {
+		t.Fatal(err)
+	}
+	for path, owner := range expected {
+		info, err := os.Lstat(filepath.Join(destination, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if int(stat.Uid) != owner.uid || int(stat.Gid) != owner.gid || info.Mode().Perm() != owner.mode {
+			t.Fatalf("%s: expected %d:%d %v, got %d:%d %v", path, owner.uid, owner.gid, owner.mode, stat.Uid, stat.Gid, info.Mode().Perm())
+		}
+	}
+}
+
+func TestCopyOwnershipSymlinkInVolume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	if err := os.MkdirAll(filepath.Join(source, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(source, "etc", "passwd"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(tmp, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "passwd"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a volume left by another container, pointing out of itself
+	destination := filepath.Join(tmp, "volume")
+	if err := os.Mkdir(destination, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(destination, "etc")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []func() error{
+		func() error { return copyOwnership(source, destination) },
+		func() error { return copyExistingContents(source, destination, copySkipIfNonEmpty) },
+	} {
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(outside, "passwd"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0644 {
+			t.Fatalf("expected the file outside of the volume to be left alone, got mode %v", info.Mode().Perm())
+		}
+	}
+}
+
+func TestCopyExistingContentsKeepsUserData(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	destination := filepath.Join(tmp, "volume")
+	for _, root := range []string{source, destination} {
+		if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(source, "a", "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(destination, "a", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(source, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyExistingContents(source, destination, copySkipIfNonEmpty); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(destination, "a", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("expected the data of a non-empty volume to keep its mode, got %v", info.Mode().Perm())
+	}
+	if info, err = os.Stat(destination); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("expected the root of the volume to take the mode of the image directory, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
+
+func TestParseVolumesFromSpec(t *testing.T) {
+	valid := map[string]struct {
+		writable, copyData bool
+	}{
+		"foo":         {true, false},
+		"foo:rw":      {true, false},
+		"foo:ro":      {false, false},
+		"foo:copy":    {true, true},
+		"foo:rw,copy": {true, true},
+		"foo:copy,ro": {false, true},
+	}
+	for spec, expected := range valid {
+		id, writable, copyData, err := parseVolumesFromSpec(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if id != "foo" || writable != expected.writable || copyData != expected.copyData {
+			t.Fatalf("%s: expected foo, %v, %v, got %s, %v, %v", spec, expected.writable, expected.copyData, id, writable, copyData)
+		}
+	}
+
+	for _, spec := range []string{"foo:", "foo:z", "foo:ro,rw", "foo:copy,copy"} {
+		if _, _, _, err := parseVolumesFromSpec(spec); err == nil {
+			t.Fatalf("expected %s to be rejected", spec)
+		}
+	}
+}
+
+func TestApplyVolumesFromCopy(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{
+		containers: &contStore{s: make(map[string]*Container)},
+		volumes:    repo,
+	}
+
+	for i, mode := range []string{"", ":copy"} {
+		v, err := repo.FindOrCreateVolume("", true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from := &Container{
+			ID:        fmt.Sprintf("from%d", i),
+			Volumes:   map[string]string{"/data": v.Path},
+			VolumesRW: map[string]bool{"/data": true},
+			daemon:    daemon,
+		}
+		daemon.containers.Add(from.ID, from)
+
+		basefs := filepath.Join(tmp, fmt.Sprintf("rootfs%d", i))
+		if err := os.MkdirAll(filepath.Join(basefs, "data"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(basefs, "data", "file"), []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		container := &Container{
+			ID:         fmt.Sprintf("container%d", i),
+			basefs:     basefs,
+			Volumes:    make(map[string]string),
+			VolumesRW:  make(map[string]bool),
+			hostConfig: &runconfig.HostConfig{VolumesFrom: []string{from.ID + mode}},
+			daemon:     daemon,
+		}
+		if err := container.applyVolumesFrom(); err != nil {
+			t.Fatal(err)
+		}
+		if container.Volumes["/data"] != v.Path {
+			t.Fatalf("expected the volume of %s to be mounted at /data, got %v", from.ID, container.Volumes)
+		}
+
+		_, err = os.Stat(filepath.Join(v.Path, "file"))
+		if copied := err == nil; copied != (mode == ":copy") {
+			t.Fatalf("volumes-from %s: expected image content to be copied: %v, got %v", mode, mode == ":copy", copied)
+		}
+	}
+}
+
+func TestMountInitializeScopeError(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	basefs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(basefs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/loop", filepath.Join(basefs, "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mount{
+		MountToPath: "/loop/data",
+		volume:      &volumes.Volume{Path: filepath.Join(tmp, "volume")},
+		container: &Container{
+			basefs:    basefs,
+			Volumes:   make(map[string]string),
+			VolumesRW: make(map[string]bool),
+		},
+	}
+	err = m.initialize()
+	if err == nil {
+		t.Fatal("expected a mount point behind a symlink loop to fail")
+	}
+	if !strings.Contains(err.Error(), "/loop/data") || !strings.Contains(err.Error(), basefs) {
+		t.Fatalf("expected error to name the mount point and the container root, got %v", err)
+	}
+}
+
+func TestApplyVolumesFromConflicts(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{
+		containers: &contStore{s: make(map[string]*Container)},
+		volumes:    repo,
+	}
+	for _, id := range []string{"from1", "from2"} {
+		v, err := repo.FindOrCreateVolume("", true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		daemon.containers.Add(id, &Container{
+			ID:        id,
+			Volumes:   map[string]string{"/data": v.Path},
+			VolumesRW: map[string]bool{"/data": true},
+			daemon:    daemon,
+		})
+	}
+
+	for _, hostConfig := range []*runconfig.HostConfig{
+		{VolumesFrom: []string{"from1", "from2"}},
+		{VolumesFrom: []string{"from1"}, Binds: []string{"/host:/data"}},
+		{VolumesFrom: []string{"from1"}, Binds: []string{"myvol:/data"}},
+	} {
+		container := &Container{
+			ID:         "1234",
+			basefs:     tmp,
+			Volumes:    make(map[string]string),
+			VolumesRW:  make(map[string]bool),
+			hostConfig: hostConfig,
+			daemon:     daemon,
+		}
+		err := container.applyVolumesFrom()
+		if err == nil || !strings.Contains(err.Error(), "Duplicate volume \"/data\"") || !strings.Contains(err.Error(), "from1") {
+			t.Fatalf("expected a conflict on /data with from1 for %+v, got %v", hostConfig, err)
+		}
+	}
+}
+
+func TestApplyVolumesFromSharedVolume(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{
+		containers: &contStore{s: make(map[string]*Container)},
+		volumes:    repo,
+	}
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b got the volume of a through volumes-from
+	for _, id := range []string{"a", "b"} {
+		daemon.containers.Add(id, &Container{
+			ID:        id,
+			Volumes:   map[string]string{"/data": v.Path},
+			VolumesRW: map[string]bool{"/data": true},
+			daemon:    daemon,
+		})
+	}
+
+	container := &Container{
+		ID:         "1234",
+		basefs:     tmp,
+		Volumes:    make(map[string]string),
+		VolumesRW:  make(map[string]bool),
+		hostConfig: &runconfig.HostConfig{VolumesFrom: []string{"a", "b"}},
+		daemon:     daemon,
+	}
+	if err := container.applyVolumesFrom(); err != nil {
+		t.Fatal(err)
+	}
+	if container.Volumes["/data"] != v.Path {
+		t.Fatalf("expected the shared volume at /data, got %q", container.Volumes["/data"])
+	}
+}
+
+func TestCreateVolumesMaxVolumes(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &Daemon{
+		containers: &contStore{s: make(map[string]*Container)},
+		volumes:    repo,
+		config:     &Config{MaxVolumes: 2},
+	}
+	container := &Container{
+		ID:     "1234",
+		basefs: tmp,
+		Config: &runconfig.Config{
+			Volumes: map[string]struct{}{"/a": {}, "/b": {}},
+		},
+		hostConfig: &runconfig.HostConfig{Binds: []string{filepath.Join(tmp, "host") + ":/c"}},
+		daemon:     daemon,
+	}
+
+	err = container.prepareVolumes()
+	if err == nil || !strings.Contains(err.Error(), "3 volumes, more than the maximum of 2") {
+		t.Fatalf("expected the volume limit to be enforced, got %v", err)
+	}
+	if len(repo.List()) != 0 {
+		t.Fatalf("expected no volume to be created, got %d", len(repo.List()))
+	}
+
+	// volumes from other containers count too, once per path
+	daemon.containers.Add("from", &Container{
+		ID:      "from",
+		Volumes: map[string]string{"/a": "/vol/a", "/d": "/vol/d"},
+		daemon:  daemon,
+	})
+	container.hostConfig = &runconfig.HostConfig{VolumesFrom: []string{"from:ro"}}
+	container.Config.Volumes = map[string]struct{}{"/a": {}}
+	daemon.config.MaxVolumes = 2
+	if err := container.checkVolumeCount(); err != nil {
+		t.Fatalf("expected /a and /d to fit the limit, got %v", err)
+	}
+	daemon.config.MaxVolumes = 1
+	err = container.checkVolumeCount()
+	if err == nil || !strings.Contains(err.Error(), "2 volumes, more than the maximum of 1") {
+		t.Fatalf("expected the volumes from other containers to be counted, got %v", err)
+	}
+}
+
+func TestReconcileVolumeReferences(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("unloadable")
+	v.AddContainer("removed")
+
+	// the directory of a container whose config cannot be loaded
+	repository := filepath.Join(tmp, "containers")
+	if err := os.MkdirAll(filepath.Join(repository, "unloadable"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := &Daemon{repository: repository, volumes: repo}
+	if err := daemon.reconcileVolumeReferences(); err != nil {
+		t.Fatal(err)
+	}
+	if containers := v.Containers(); !reflect.DeepEqual(containers, []string{"unloadable"}) {
+		t.Fatalf("expected containers [unloadable], got %v", containers)
+	}
+}
+
+func TestRegisterVolumesRestoresReferences(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	repo.SetEventHandler(func(action string, v *volumes.Volume, containerID string) {
+		events = append(events, action)
+	})
+
+	container := &Container{
+		ID:        "1234",
+		Volumes:   map[string]string{"/data": v.Path},
+		VolumesRW: map[string]bool{"/data": true},
+		daemon:    &Daemon{volumes: repo},
+	}
+	container.registerVolumes()
+
+	if containers := v.Containers(); !reflect.DeepEqual(containers, []string{"1234"}) {
+		t.Fatalf("expected containers [1234], got %v", containers)
+	}
+	if meta := v.Metadata(); !meta.LastUsedAt.IsZero() {
+		t.Fatalf("expected restoring a container not to mark the volume used, got %v", meta.LastUsedAt)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no volume events when restoring a container, got %v", events)
+	}
+}
+
+func TestRebindVolumeInUse(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "docker-volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := newRepo(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := filepath.Join(tmp, "old")
+	newPath := filepath.Join(tmp, "new")
+	for _, p := range []string{oldPath, newPath} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := repo.FindOrCreateVolume(oldPath, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := &Daemon{volumes: repo}
+	container := &Container{
+		ID:        "1234",
+		Volumes:   map[string]string{"/data": oldPath},
+		VolumesRW: map[string]bool{"/data": true},
+		daemon:    daemon,
+	}
+	container.registerVolumes()
+
+	if err := repo.Rebind(v.ID, newPath); err == nil {
+		t.Fatalf("expected rebinding a volume used by a container to fail")
+	}
+	mounts := container.VolumeMounts()
+	if m, exists := mounts["/data"]; !exists || m.volume != v {
+		t.Fatalf("expected the container to still mount volume %s, got %v", v.ID, mounts)
+	}
+
+	// once the container is gone the volume can be moved
+	container.derefVolumes()
+	if err := repo.Rebind(v.ID, newPath); err != nil {
+		t.Fatal(err)
+	}
+	if repo.Get(newPath) != v {
+		t.Fatalf("expected volume %s at %s", v.ID, newPath)
+	}
+}
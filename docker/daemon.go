@@ -3,10 +3,14 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	gosignal "os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/autogen/dockerversion"
@@ -136,6 +140,17 @@ func mainDaemon() {
 		log.Debugf("daemon finished")
 	}()
 
+	// Reload the TLS certificates of the api on SIGHUP
+	go func() {
+		c := make(chan os.Signal, 1)
+		gosignal.Notify(c, syscall.SIGHUP)
+		for _ = range c {
+			if err := eng.Job("reloadtls").Run(); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
 	// Serve api
 	job := eng.Job("serveapi", flHosts...)
 	job.SetenvBool("Logging", true)
@@ -149,8 +164,24 @@ func mainDaemon() {
 	job.Setenv("TlsCa", *flCa)
 	job.Setenv("TlsCert", *flCert)
 	job.Setenv("TlsKey", *flKey)
+	if daemonCfg.TlsCipherSuites != "" {
+		job.SetenvList("TlsCipherSuites", strings.Split(daemonCfg.TlsCipherSuites, ","))
+	}
 	job.SetenvBool("BufferRequests", true)
-	err := job.Run()
+
+	// Log what serveapi reports, such as when every listener is bound
+	stdout, err := job.Stdout.AddPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			log.Infof("API %s", scanner.Text())
+		}
+	}()
+
+	err = job.Run()
 
 	// Wait for the daemon startup goroutine to finish
 	// This makes sure we can actually cleanly shutdown the daemon
@@ -5,29 +5,140 @@
 */
 package listenbuffer
 
-import "net"
+import (
+	"errors"
+	"net"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var errClosed = errors.New("use of closed listener")
 
 // NewListenBuffer returns a listener listening on addr with the protocol.
-func NewListenBuffer(proto, addr string, activate chan struct{}) (net.Listener, error) {
+// Connections are accepted and held until activate is closed, at most max
+// of them when max is positive; connections beyond that are closed.
+func NewListenBuffer(proto, addr string, activate chan struct{}, max int) (net.Listener, error) {
 	wrapped, err := net.Listen(proto, addr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &defaultListener{
+	l := &defaultListener{
 		wrapped:  wrapped,
 		activate: activate,
-	}, nil
+		max:      max,
+		accepted: make(chan acceptResult, 1),
+		closed:   make(chan struct{}),
+	}
+	go l.buffer()
+	return l, nil
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
 }
 
 type defaultListener struct {
 	wrapped  net.Listener // the real listener to wrap
-	ready    bool         // is the listner ready to start accpeting connections
 	activate chan struct{}
+	max      int // the maximum number of connections to buffer, if positive
+	accepted chan acceptResult
+	closed   chan struct{} // closed when the listener is closed
+
+	lock     sync.Mutex
+	ready    bool       // is the listner ready to start accpeting connections
+	pending  []net.Conn // the connections held until the listener is ready
+	buffered int        // the number of connections held until the listener was ready
+	dropped  int        // the number of connections closed for exceeding max
+	err      error      // the error which stopped the wrapped listener
+}
+
+// buffer accepts connections on the wrapped listener, holding them until
+// the listener is ready and handing them to Accept after that.
+func (l *defaultListener) buffer() {
+	for {
+		conn, err := l.wrapped.Accept()
+		if err != nil {
+			if !l.send(acceptResult{nil, err}) || !isTemporary(err) {
+				return
+			}
+			continue
+		}
+
+		l.lock.Lock()
+		if !l.ready {
+			if l.max > 0 && len(l.pending) >= l.max {
+				l.dropped++
+				l.lock.Unlock()
+				conn.Close()
+				continue
+			}
+			l.pending = append(l.pending, conn)
+			l.lock.Unlock()
+			continue
+		}
+		l.lock.Unlock()
+
+		if !l.send(acceptResult{conn, nil}) {
+			return
+		}
+	}
+}
+
+// send hands result to Accept, closing its connection instead and returning
+// false if the listener is closed first.
+func (l *defaultListener) send(result acceptResult) bool {
+	select {
+	case l.accepted <- result:
+	case <-l.closed:
+		if result.conn != nil {
+			result.conn.Close()
+		}
+		return false
+	}
+
+	// the listener may have been closed, and drained, while sending
+	select {
+	case <-l.closed:
+		l.drain()
+		return false
+	default:
+		return true
+	}
+}
+
+// drain closes the connection waiting to be accepted, if any.
+func (l *defaultListener) drain() {
+	for {
+		select {
+		case result := <-l.accepted:
+			if result.conn != nil {
+				result.conn.Close()
+			}
+		default:
+			return
+		}
+	}
 }
 
 func (l *defaultListener) Close() error {
-	return l.wrapped.Close()
+	l.lock.Lock()
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	for _, conn := range l.pending {
+		conn.Close()
+	}
+	l.pending = nil
+	l.lock.Unlock()
+
+	err := l.wrapped.Close()
+	l.drain()
+	return err
 }
 
 func (l *defaultListener) Addr() net.Addr {
@@ -35,12 +146,47 @@ func (l *defaultListener) Addr() net.Addr {
 }
 
 func (l *defaultListener) Accept() (net.Conn, error) {
-	// if the listen has been told it is ready then we can go ahead and
-	// start returning connections
-	if l.ready {
-		return l.wrapped.Accept()
-	}
-	<-l.activate
-	l.ready = true
-	return l.Accept()
+	// connections are only returned once the listener has been told it is
+	// ready, starting with the ones held until then
+	select {
+	case <-l.activate:
+	case <-l.closed:
+		return nil, errClosed
+	}
+
+	l.lock.Lock()
+	if !l.ready {
+		l.ready = true
+		l.buffered = len(l.pending)
+		log.Infof("Accepting connections on %s, %d were buffered and %d dropped while starting", l.Addr(), l.buffered, l.dropped)
+	}
+	if l.err != nil {
+		l.lock.Unlock()
+		return nil, l.err
+	}
+	if len(l.pending) > 0 {
+		conn := l.pending[0]
+		l.pending = l.pending[1:]
+		l.lock.Unlock()
+		return conn, nil
+	}
+	l.lock.Unlock()
+
+	var result acceptResult
+	select {
+	case result = <-l.accepted:
+	case <-l.closed:
+		return nil, errClosed
+	}
+	if result.err != nil && !isTemporary(result.err) {
+		l.lock.Lock()
+		l.err = result.err
+		l.lock.Unlock()
+	}
+	return result.conn, result.err
+}
+
+func isTemporary(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Temporary()
 }
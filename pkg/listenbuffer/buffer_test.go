@@ -0,0 +1,176 @@
+package listenbuffer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// waitBuffered waits for the listener to have handled n connections
+func waitBuffered(t *testing.T, l *defaultListener, n int) {
+	for i := 0; i < 100; i++ {
+		l.lock.Lock()
+		handled := len(l.pending) + l.dropped
+		l.lock.Unlock()
+		if handled >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d connections to be buffered", n)
+}
+
+func TestListenBufferActivation(t *testing.T) {
+	activate := make(chan struct{})
+	ln, err := NewListenBuffer("tcp", "127.0.0.1:0", activate, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := ln.(*defaultListener)
+
+	n := 5
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	waitBuffered(t, l, n)
+
+	chAccepted := make(chan error)
+	go func() {
+		for i := 0; i < n+1; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				chAccepted <- err
+				return
+			}
+			conn.Close()
+			chAccepted <- nil
+		}
+	}()
+
+	select {
+	case <-chAccepted:
+		t.Fatal("Accept returned before activation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(activate)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-chAccepted:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out accepting buffered connection %d", i)
+		}
+	}
+	if l.buffered != n {
+		t.Fatalf("Expected %d buffered connections to be reported, got %d", n, l.buffered)
+	}
+
+	// Connections made after activation are accepted right away
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-chAccepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out accepting connection after activation")
+	}
+}
+
+func TestListenBufferMax(t *testing.T) {
+	activate := make(chan struct{})
+	ln, err := NewListenBuffer("tcp", "127.0.0.1:0", activate, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := ln.(*defaultListener)
+
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	waitBuffered(t, l, 4)
+
+	l.lock.Lock()
+	pending, dropped := len(l.pending), l.dropped
+	l.lock.Unlock()
+	if pending != 2 || dropped != 2 {
+		t.Fatalf("Expected 2 buffered and 2 dropped connections, got %d and %d", pending, dropped)
+	}
+
+	close(activate)
+	for i := 0; i < 2; i++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	if l.buffered != 2 {
+		t.Fatalf("Expected 2 buffered connections to be reported, got %d", l.buffered)
+	}
+}
+
+func TestListenBufferCloseAccepted(t *testing.T) {
+	activate := make(chan struct{})
+	ln, err := NewListenBuffer("tcp", "127.0.0.1:0", activate, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ln.(*defaultListener)
+
+	close(activate)
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// the next connection waits to be handed out by Accept
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	for i := 0; len(l.accepted) == 0; i++ {
+		if i == 100 {
+			t.Fatal("Timed out waiting for the connection to be accepted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ln.Accept(); err == nil {
+		t.Fatal("Expected Accept to fail once the listener is closed")
+	}
+
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the connection waiting to be accepted to be closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("Timed out waiting for the connection waiting to be accepted to be closed")
+	}
+}
@@ -2,6 +2,7 @@ package parsers
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -46,20 +47,20 @@ func ParseTCPAddr(addr string, defaultAddr string) (string, error) {
 		return "", fmt.Errorf("Invalid proto, expected tcp: %s", addr)
 	}
 
-	hostParts := strings.Split(addr, ":")
-	if len(hostParts) != 2 {
+	// IPv6 hosts must be in brackets, as in [::1]:2375
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
 		return "", fmt.Errorf("Invalid bind address format: %s", addr)
 	}
-	host := hostParts[0]
 	if host == "" {
 		host = defaultAddr
 	}
 
-	p, err := strconv.Atoi(hostParts[1])
+	p, err := strconv.Atoi(port)
 	if err != nil && p == 0 {
 		return "", fmt.Errorf("Invalid bind address format: %s", addr)
 	}
-	return fmt.Sprintf("tcp://%s:%d", host, p), nil
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(host, strconv.Itoa(p))), nil
 }
 
 // Get a repos name and returns the right reposName + tag|digest
@@ -25,6 +25,15 @@ func TestParseHost(t *testing.T) {
 	if addr, err := ParseHost(defaultHttpHost, defaultUnix, "tcp://:7777"); err != nil || addr != "tcp://127.0.0.1:7777" {
 		t.Errorf("tcp://:7777 -> expected tcp://127.0.0.1:7777, got %s", addr)
 	}
+	if addr, err := ParseHost(defaultHttpHost, defaultUnix, "tcp://[::1]:2376"); err != nil || addr != "tcp://[::1]:2376" {
+		t.Errorf("tcp://[::1]:2376 -> expected tcp://[::1]:2376, got %s", addr)
+	}
+	if addr, err := ParseHost(defaultHttpHost, defaultUnix, "[fe80::1%eth0]:2375"); err != nil || addr != "tcp://[fe80::1%eth0]:2375" {
+		t.Errorf("[fe80::1%%eth0]:2375 -> expected tcp://[fe80::1%%eth0]:2375, got %s", addr)
+	}
+	if addr, err := ParseHost(defaultHttpHost, defaultUnix, "tcp://::1:2376"); err == nil {
+		t.Errorf("unbracketed IPv6 address expected error return, but err == nil, got %s", addr)
+	}
 	if addr, err := ParseHost(defaultHttpHost, defaultUnix, ""); err != nil || addr != "unix:///var/run/docker.sock" {
 		t.Errorf("empty argument -> expected unix:///var/run/docker.sock, got %s", addr)
 	}
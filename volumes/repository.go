@@ -5,21 +5,70 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/docker/pkg/common"
+	"github.com/docker/docker/pkg/truncindex"
 )
 
+const validVolumeNameChars = `[a-zA-Z0-9][a-zA-Z0-9_.-]`
+
+var validVolumeName = regexp.MustCompile(`^` + validVolumeNameChars + `+$`)
+
+// validVolumeID matches the IDs generated for volumes, which are used in
+// paths on disk.
+var validVolumeID = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// maxIDAttempts is how many IDs are generated for a new volume before
+// giving up on finding one which is valid and not in use.
+const maxIDAttempts = 10
+
+// pruneGracePeriod is how long a new volume is kept from being pruned, as the
+// container it is created for only starts using it once it is set up.
+const pruneGracePeriod = 10 * time.Minute
+
+// EventFunc is called on volume lifecycle events: "create", "destroy",
+// "mount" and "unmount". containerID is only set for mount and unmount.
+type EventFunc func(action string, v *Volume, containerID string)
+
 type Repository struct {
 	configPath string
 	driver     graphdriver.Driver
 	volumes    map[string]*Volume
-	lock       sync.Mutex
+	names      map[string]*Volume
+	idIndex    *truncindex.TruncIndex
+	// creating tracks bind mount volumes being created, so concurrent
+	// requests for the same path wait for a single creation.
+	creating map[string]chan struct{}
+	events   EventFunc
+	// strict makes restore fail on the first volume it cannot restore,
+	// otherwise the IDs of such volumes are collected in failed.
+	strict bool
+	failed []string
+	// generateID returns candidate IDs for new volumes
+	generateID func() string
+	// now is the clock used for volume timestamps
+	now  func() time.Time
+	lock sync.Mutex
 }
 
 func NewRepository(configPath string, driver graphdriver.Driver) (*Repository, error) {
+	return newRepository(configPath, driver, false)
+}
+
+// NewRepositoryStrict is like NewRepository but returns an error if any of
+// the existing volumes cannot be restored, instead of skipping it.
+func NewRepositoryStrict(configPath string, driver graphdriver.Driver) (*Repository, error) {
+	return newRepository(configPath, driver, true)
+}
+
+func newRepository(configPath string, driver graphdriver.Driver, strict bool) (*Repository, error) {
 	abspath, err := filepath.Abs(configPath)
 	if err != nil {
 		return nil, err
@@ -34,17 +83,26 @@ func NewRepository(configPath string, driver graphdriver.Driver) (*Repository, e
 		driver:     driver,
 		configPath: abspath,
 		volumes:    make(map[string]*Volume),
+		names:      make(map[string]*Volume),
+		idIndex:    truncindex.NewTruncIndex([]string{}),
+		creating:   make(map[string]chan struct{}),
+		strict:     strict,
+		generateID: common.GenerateRandomID,
+		now:        time.Now,
 	}
 
 	return repo, repo.restore()
 }
 
-func (r *Repository) newVolume(path string, writable bool) (*Volume, error) {
-	var (
-		isBindMount bool
-		err         error
-		id          = common.GenerateRandomID()
-	)
+// newVolume creates and registers a new volume. The slow part of the work,
+// such as creating the volume on the driver, is done without holding the
+// repository lock, which is only taken to register the volume.
+func (r *Repository) newVolume(name, path string, writable bool, labels map[string]string) (*Volume, error) {
+	var isBindMount bool
+	id, err := r.newID()
+	if err != nil {
+		return nil, err
+	}
 	if path != "" {
 		isBindMount = true
 	}
@@ -55,6 +113,33 @@ func (r *Repository) newVolume(path string, writable bool) (*Volume, error) {
 			return nil, err
 		}
 	}
+	return r.register(id, name, path, isBindMount, writable, copyLabels(labels))
+}
+
+// newID returns an ID for a new volume, retrying when the generated ID is
+// not a valid volume ID or is already in use.
+func (r *Repository) newID() (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id := r.generateID()
+		if !validVolumeID.MatchString(id) {
+			log.Debugf("Discarding invalid volume ID %q", id)
+			continue
+		}
+		r.lock.Lock()
+		_, err := r.idIndex.Get(id)
+		r.lock.Unlock()
+		if err == nil {
+			log.Debugf("Discarding volume ID %s already in use", id)
+			continue
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("Could not generate a valid volume ID after %d attempts", maxIDAttempts)
+}
+
+// register records a volume whose data is at path, which already exists on
+// the driver unless the volume is a bind mount.
+func (r *Repository) register(id, name, path string, isBindMount, writable bool, labels map[string]string) (*Volume, error) {
 	path = filepath.Clean(path)
 
 	// Ignore the error here since the path may not exist
@@ -65,19 +150,50 @@ func (r *Repository) newVolume(path string, writable bool) (*Volume, error) {
 
 	v := &Volume{
 		ID:          id,
+		Name:        name,
 		Path:        path,
 		repository:  r,
 		Writable:    writable,
+		Labels:      labels,
+		CreatedAt:   r.now().UTC(),
 		containers:  make(map[string]struct{}),
 		configPath:  r.configPath + "/" + id,
 		IsBindMount: isBindMount,
 	}
 
 	if err := v.initialize(); err != nil {
+		r.cleanupVolume(v)
 		return nil, err
 	}
 
-	return v, r.add(v)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.add(v); err != nil {
+		r.cleanupVolume(v)
+		return nil, err
+	}
+	r.logEvent("create", v, "")
+	return v, nil
+}
+
+// cleanupVolume removes what was created on disk for a volume which could
+// not be registered, so that no orphaned data is left behind.
+func (r *Repository) cleanupVolume(v *Volume) {
+	if err := r.removeVolumeData(v); err != nil {
+		log.Errorf("Error cleaning up volume %s: %v", v.ID, err)
+	}
+}
+
+// SetEventHandler registers fn to be notified of volume lifecycle events.
+// It must be called before the repository is shared.
+func (r *Repository) SetEventHandler(fn EventFunc) {
+	r.events = fn
+}
+
+func (r *Repository) logEvent(action string, v *Volume, containerID string) {
+	if r.events != nil {
+		r.events(action, v, containerID)
+	}
 }
 
 func (r *Repository) restore() error {
@@ -88,28 +204,41 @@ func (r *Repository) restore() error {
 
 	for _, v := range dir {
 		id := v.Name()
-		vol := &Volume{
-			ID:         id,
-			configPath: r.configPath + "/" + id,
-			containers: make(map[string]struct{}),
-		}
-		if err := vol.FromDisk(); err != nil {
-			if !os.IsNotExist(err) {
-				log.Debugf("Error restoring volume: %v", err)
-				continue
+		if err := r.restoreVolume(id); err != nil {
+			if r.strict {
+				return fmt.Errorf("Error restoring volume %s: %v", id, err)
 			}
-			if err := vol.initialize(); err != nil {
-				log.Debugf("%s", err)
-				continue
-			}
-		}
-		if err := r.add(vol); err != nil {
-			log.Debugf("Error restoring volume: %v", err)
+			log.Errorf("Error restoring volume %s: %v", id, err)
+			r.failed = append(r.failed, id)
 		}
 	}
 	return nil
 }
 
+func (r *Repository) restoreVolume(id string) error {
+	vol := &Volume{
+		ID:         id,
+		configPath: r.configPath + "/" + id,
+		repository: r,
+		containers: make(map[string]struct{}),
+	}
+	if err := vol.FromDisk(); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := vol.initialize(); err != nil {
+			return err
+		}
+	}
+	return r.add(vol)
+}
+
+// FailedRestores returns the IDs of the volumes which could not be restored
+// when the repository was created.
+func (r *Repository) FailedRestores() []string {
+	return r.failed
+}
+
 func (r *Repository) Get(path string) *Volume {
 	r.lock.Lock()
 	vol := r.get(path)
@@ -117,6 +246,149 @@ func (r *Repository) Get(path string) *Volume {
 	return vol
 }
 
+// IsConfigPath returns whether path is the directory where the repository
+// keeps the volume configs, or is inside it.
+func (r *Repository) IsConfigPath(path string) bool {
+	configPath := r.configPath
+	if resolved, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	path = filepath.Clean(path)
+	return path == configPath || strings.HasPrefix(path, configPath+string(filepath.Separator))
+}
+
+// List returns a snapshot of all the volumes known to the repository.
+func (r *Repository) List() []*Volume {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	volumes := make([]*Volume, 0, len(r.volumes))
+	for _, v := range r.volumes {
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
+
+// GetByID returns the volume with the given ID, or unambiguous ID prefix.
+func (r *Repository) GetByID(id string) *Volume {
+	r.lock.Lock()
+	vol := r.getByID(id)
+	r.lock.Unlock()
+	return vol
+}
+
+func (r *Repository) getByID(id string) *Volume {
+	id, err := r.idIndex.Get(id)
+	if err != nil {
+		return nil
+	}
+	for _, v := range r.volumes {
+		if v.ID == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// Filter returns the volumes backed by driver and, when inUse is set, whose
+// use by containers matches it. An empty driver matches all volumes.
+func (r *Repository) Filter(driver string, inUse *bool) []*Volume {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var volumes []*Volume
+	for _, v := range r.volumes {
+		if driver != "" && v.DriverName() != driver {
+			continue
+		}
+		if inUse != nil && (len(v.Containers()) > 0) != *inUse {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
+
+// ReconcileReferences drops, from every volume, the references to containers
+// which are not in liveContainerIDs, for instance containers which went away
+// while the daemon was down. References are never added.
+func (r *Repository) ReconcileReferences(liveContainerIDs []string) {
+	live := make(map[string]struct{}, len(liveContainerIDs))
+	for _, id := range liveContainerIDs {
+		live[id] = struct{}{}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, v := range r.volumes {
+		for _, id := range v.Containers() {
+			if _, exists := live[id]; !exists {
+				log.Debugf("Dropping stale reference of volume %s to container %s", v.ID, id)
+				v.RemoveContainer(id)
+			}
+		}
+	}
+}
+
+// Rebind points the bind mount volume with the given ID at newPath, for
+// instance after its data was moved to another place on the host. The
+// volume keeps its ID, name and labels. Volumes used by containers cannot be
+// rebound, since the containers record the path they mount.
+func (r *Repository) Rebind(id, newPath string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	v := r.getByID(id)
+	if v == nil {
+		return fmt.Errorf("Volume %s does not exist", id)
+	}
+	if !v.IsBindMount {
+		return fmt.Errorf("Volume %s is managed by the %s driver and cannot be rebound", v.ID, v.DriverName())
+	}
+	if containers := v.Containers(); len(containers) > 0 {
+		return fmt.Errorf("Volume %s is being used and cannot be rebound: used by containers %s", v.ID, containers)
+	}
+
+	path, err := filepath.EvalSymlinks(newPath)
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	if r.IsConfigPath(path) {
+		return fmt.Errorf("Cannot rebind volume %s to %s: it is used to store volume configs", v.ID, path)
+	}
+	if other, exists := r.volumes[path]; exists {
+		if other == v {
+			return nil
+		}
+		return fmt.Errorf("Cannot rebind volume %s to %s: it is already used by volume %s", v.ID, path, other.ID)
+	}
+
+	v.lock.Lock()
+	oldPath := v.Path
+	v.Path = path
+	if err := v.toDisk(); err != nil {
+		v.Path = oldPath
+		v.lock.Unlock()
+		return err
+	}
+	v.lock.Unlock()
+
+	delete(r.volumes, oldPath)
+	r.volumes[path] = v
+	return nil
+}
+
+// GetByName returns the volume created with the given name.
+func (r *Repository) GetByName(name string) *Volume {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.names[name]
+}
+
 func (r *Repository) get(path string) *Volume {
 	path, err := filepath.EvalSymlinks(path)
 	if err != nil {
@@ -129,7 +401,18 @@ func (r *Repository) add(volume *Volume) error {
 	if vol := r.get(volume.Path); vol != nil {
 		return fmt.Errorf("Volume exists: %s", volume.ID)
 	}
+	if volume.Name != "" {
+		if vol, exists := r.names[volume.Name]; exists {
+			return fmt.Errorf("Volume name %q is already in use by %s", volume.Name, vol.ID)
+		}
+	}
+	if err := r.idIndex.Add(volume.ID); err != nil {
+		return err
+	}
 	r.volumes[volume.Path] = volume
+	if volume.Name != "" {
+		r.names[volume.Name] = volume
+	}
 	return nil
 }
 
@@ -145,11 +428,31 @@ func (r *Repository) Delete(path string) error {
 		return fmt.Errorf("Volume %s does not exist", path)
 	}
 
+	return r.delete(volume)
+}
+
+func (r *Repository) delete(volume *Volume) error {
 	containers := volume.Containers()
 	if len(containers) > 0 {
 		return fmt.Errorf("Volume %s is being used and cannot be removed: used by containers %s", volume.Path, containers)
 	}
 
+	if err := r.removeVolumeData(volume); err != nil {
+		return err
+	}
+
+	r.idIndex.Delete(volume.ID)
+	delete(r.volumes, volume.Path)
+	if volume.Name != "" {
+		delete(r.names, volume.Name)
+	}
+	r.logEvent("destroy", volume, "")
+	return nil
+}
+
+// removeVolumeData removes the config of volume and, unless it is a bind
+// mount, its data on the driver.
+func (r *Repository) removeVolumeData(volume *Volume) error {
 	if err := os.RemoveAll(volume.configPath); err != nil {
 		return err
 	}
@@ -161,11 +464,148 @@ func (r *Repository) Delete(path string) error {
 			}
 		}
 	}
-
-	delete(r.volumes, volume.Path)
 	return nil
 }
 
+// Prune deletes every volume managed by the repository which is not used by
+// any container, and returns the IDs of the removed volumes. Bind mounts are
+// left alone since their data belongs to the user, and volumes created in the
+// last pruneGracePeriod since they may be about to be used.
+func (r *Repository) Prune() ([]string, error) {
+	return r.prune(func(*Volume) bool { return true })
+}
+
+// PruneOlderThan is like Prune but only removes the volumes which have not
+// been used, or touched, for at least age.
+func (r *Repository) PruneOlderThan(age time.Duration) ([]string, error) {
+	threshold := r.now().UTC().Add(-age)
+	return r.prune(func(v *Volume) bool {
+		return !v.lastUsed().After(threshold)
+	})
+}
+
+func (r *Repository) prune(filter func(*Volume) bool) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var (
+		removed []string
+		created = r.now().UTC().Add(-pruneGracePeriod)
+	)
+	for _, v := range r.volumes {
+		if v.IsBindMount || len(v.Containers()) > 0 || v.Metadata().CreatedAt.After(created) || !filter(v) {
+			continue
+		}
+		if err := r.delete(v); err != nil {
+			return removed, err
+		}
+		removed = append(removed, v.ID)
+	}
+	return removed, nil
+}
+
+// Snapshotter is implemented by volume drivers which can copy a volume
+// cheaply, for instance with a copy-on-write snapshot.
+type Snapshotter interface {
+	// Snapshot creates the volume id on the driver as a copy of parent.
+	Snapshot(id, parent string) error
+}
+
+// Clone creates a new volume, on the repository's driver, holding a copy of
+// the data of the volume with the given ID. The clone gets the source's mode
+// and labels but is not used by any container.
+func (r *Repository) Clone(srcID string) (*Volume, error) {
+	src := r.GetByID(srcID)
+	if src == nil {
+		return nil, fmt.Errorf("Volume %s does not exist", srcID)
+	}
+	return r.clone(src, "")
+}
+
+// Snapshot is like Clone but names the new volume, and uses the driver's
+// snapshots when it is a Snapshotter. Bind mounts are always copied.
+func (r *Repository) Snapshot(srcID, name string) (*Volume, error) {
+	if !validVolumeName.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume name %q, only %s are allowed", name, validVolumeNameChars)
+	}
+	src := r.GetByID(srcID)
+	if src == nil {
+		return nil, fmt.Errorf("Volume %s does not exist", srcID)
+	}
+
+	snapshotter, ok := r.driver.(Snapshotter)
+	if !ok || src.IsBindMount {
+		return r.clone(src, name)
+	}
+
+	id, err := r.newID()
+	if err != nil {
+		return nil, err
+	}
+	if err := snapshotter.Snapshot(id, src.ID); err != nil {
+		return nil, fmt.Errorf("Driver %s failed to snapshot volume %s: %v", r.driver, src.ID, err)
+	}
+	path, err := r.driver.Get(id, "")
+	if err != nil {
+		r.driver.Remove(id)
+		return nil, fmt.Errorf("Driver %s failed to get volume rootfs %s: %v", r.driver, id, err)
+	}
+	return r.register(id, name, path, false, src.Writable, copyLabels(src.Labels))
+}
+
+func (r *Repository) clone(src *Volume, name string) (*Volume, error) {
+	v, err := r.newVolume(name, "", src.Writable, copyLabels(src.Labels))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.copyVolumeData(src, v); err != nil {
+		if derr := r.Delete(v.Path); derr != nil {
+			log.Errorf("Error removing volume %s after failed clone: %v", v.ID, derr)
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (r *Repository) copyVolumeData(src, dst *Volume) error {
+	entries, err := ioutil.ReadDir(dst.Path)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("Cannot clone volume %s: data already exists at %s", src.ID, dst.Path)
+	}
+
+	// The archive is rooted at the source's directory, renamed to the
+	// destination's so it unpacks right over it.
+	archive, err := src.Export("", filepath.Base(dst.Path))
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	return chrootarchive.Untar(archive, filepath.Dir(dst.Path), nil)
+}
+
+// Touch marks the volume at path as used without attaching a container to it.
+func (r *Repository) Touch(path string) error {
+	v := r.Get(path)
+	if v == nil {
+		return fmt.Errorf("Volume %s does not exist", path)
+	}
+	return v.Touch()
+}
+
 func (r *Repository) createNewVolumePath(id string) (string, error) {
 	if err := r.driver.Create(id, ""); err != nil {
 		return "", err
@@ -173,23 +613,69 @@ func (r *Repository) createNewVolumePath(id string) (string, error) {
 
 	path, err := r.driver.Get(id, "")
 	if err != nil {
+		r.driver.Remove(id)
 		return "", fmt.Errorf("Driver %s failed to get volume rootfs %s: %v", r.driver, id, err)
 	}
 
 	return path, nil
 }
 
-func (r *Repository) FindOrCreateVolume(path string, writable bool) (*Volume, error) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
+// FindOrCreateVolume returns the volume registered for path, creating it if
+// needed. labels are only recorded when a new volume is created.
+func (r *Repository) FindOrCreateVolume(path string, writable bool, labels map[string]string) (*Volume, error) {
 	if path == "" {
-		return r.newVolume(path, writable)
+		return r.newVolume("", path, writable, labels)
+	}
+
+	return r.findOrCreate(filepath.Clean(path), func() *Volume {
+		return r.get(path)
+	}, func() (*Volume, error) {
+		return r.newVolume("", path, writable, labels)
+	})
+}
+
+// FindOrCreateNamedVolume returns the volume created with the given name,
+// creating a new volume with that name if needed.
+func (r *Repository) FindOrCreateNamedVolume(name string, writable bool, labels map[string]string) (*Volume, error) {
+	if !validVolumeName.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume name %q, only %s are allowed", name, validVolumeNameChars)
 	}
 
-	if v := r.get(path); v != nil {
-		return v, nil
+	return r.findOrCreate(name, func() *Volume {
+		return r.names[name]
+	}, func() (*Volume, error) {
+		return r.newVolume(name, "", writable, labels)
+	})
+}
+
+// findOrCreate returns the volume found by find, or creates it. Concurrent
+// calls for the same key wait for a single creation. find is called with the
+// repository lock held, create without.
+func (r *Repository) findOrCreate(key string, find func() *Volume, create func() (*Volume, error)) (*Volume, error) {
+	for {
+		r.lock.Lock()
+		if v := find(); v != nil {
+			r.lock.Unlock()
+			return v, nil
+		}
+		wait, inFlight := r.creating[key]
+		if !inFlight {
+			break
+		}
+		r.lock.Unlock()
+		// Someone else is creating this volume, wait for them and look again
+		<-wait
 	}
+	done := make(chan struct{})
+	r.creating[key] = done
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		delete(r.creating, key)
+		close(done)
+		r.lock.Unlock()
+	}()
 
-	return r.newVolume(path, writable)
+	return create()
 }
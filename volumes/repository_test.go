@@ -4,12 +4,21 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/daemon/graphdriver"
 	_ "github.com/docker/docker/daemon/graphdriver/vfs"
+	"github.com/docker/docker/pkg/reexec"
 )
 
+func init() {
+	reexec.Init()
+}
+
 func TestRepositoryFindOrCreate(t *testing.T) {
 	root, err := ioutil.TempDir(os.TempDir(), "volumes")
 	if err != nil {
@@ -22,7 +31,7 @@ func TestRepositoryFindOrCreate(t *testing.T) {
 	}
 
 	// no path
-	v, err := repo.FindOrCreateVolume("", true)
+	v, err := repo.FindOrCreateVolume("", true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,7 +44,7 @@ func TestRepositoryFindOrCreate(t *testing.T) {
 
 	// with a non-existant path
 	dir := filepath.Join(root, "doesntexist")
-	v, err = repo.FindOrCreateVolume(dir, true)
+	v, err = repo.FindOrCreateVolume(dir, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,7 +59,7 @@ func TestRepositoryFindOrCreate(t *testing.T) {
 
 	// with a pre-existing path
 	// can just use the same path from above since it now exists
-	v, err = repo.FindOrCreateVolume(dir, true)
+	v, err = repo.FindOrCreateVolume(dir, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,7 +80,7 @@ func TestRepositoryGet(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	v, err := repo.FindOrCreateVolume("", true)
+	v, err := repo.FindOrCreateVolume("", true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,6 +94,339 @@ func TestRepositoryGet(t *testing.T) {
 	}
 }
 
+func TestRepositoryRebind(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldPath := filepath.Join(root, "old")
+	newPath := filepath.Join(root, "new")
+	for _, p := range []string{oldPath, newPath} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := repo.FindOrCreateVolume(oldPath, true, map[string]string{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("1234")
+	if err := repo.Rebind(v.ID, newPath); err == nil {
+		t.Fatalf("expected rebinding a volume in use to fail")
+	}
+	v.RemoveContainer("1234")
+
+	managed, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Rebind(managed.ID, newPath); err == nil {
+		t.Fatalf("expected rebinding a managed volume to fail")
+	}
+	if err := repo.Rebind(v.ID, managed.Path); err == nil {
+		t.Fatalf("expected rebinding onto the path of another volume to fail")
+	}
+
+	if err := repo.Rebind(v.ID, newPath); err != nil {
+		t.Fatal(err)
+	}
+	if repo.Get(oldPath) != nil {
+		t.Fatalf("expected the old path not to resolve to a volume anymore")
+	}
+
+	// the new path must be kept, with the labels, across restarts
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebound := repo.Get(newPath)
+	if rebound == nil || rebound.ID != v.ID {
+		t.Fatalf("expected to find volume %s at %s, got %v", v.ID, newPath, rebound)
+	}
+	if !reflect.DeepEqual(rebound.Labels, map[string]string{"foo": "bar"}) {
+		t.Fatalf("expected labels to be preserved, got %v", rebound.Labels)
+	}
+}
+
+func TestRepositoryGetByID(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v2 := repo.GetByID(v.ID); v2 != v {
+		t.Fatalf("expected get by id to return same volume")
+	}
+	if v2 := repo.GetByID(v.ID[:12]); v2 != v {
+		t.Fatalf("expected get by short id to return same volume")
+	}
+
+	if err := repo.Delete(v.Path); err != nil {
+		t.Fatal(err)
+	}
+	if v2 := repo.GetByID(v.ID); v2 != nil {
+		t.Fatalf("expected volume to not exist")
+	}
+}
+
+func TestRepositoryNames(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateNamedVolume("foo", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "foo" || v.IsBindMount {
+		t.Fatalf("expected a managed volume named foo, got %+v", v)
+	}
+	if v2, err := repo.FindOrCreateNamedVolume("foo", true, nil); err != nil || v2 != v {
+		t.Fatalf("expected to find the existing volume named foo (%v)", err)
+	}
+	if v2 := repo.GetByName("foo"); v2 != v {
+		t.Fatalf("expected get by name to return same volume")
+	}
+
+	if _, err := repo.FindOrCreateNamedVolume("foo/bar", true, nil); err == nil {
+		t.Fatalf("expected invalid volume name to be rejected")
+	}
+
+	// names must not collide
+	if err := repo.add(&Volume{ID: "5678", Name: "foo", Path: filepath.Join(root, "other")}); err == nil {
+		t.Fatalf("expected duplicate volume name to be rejected")
+	}
+
+	// the index must be rebuilt on restore
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = repo.GetByName("foo")
+	if v == nil {
+		t.Fatalf("expected to find volume foo after restore")
+	}
+
+	if err := repo.Delete(v.Path); err != nil {
+		t.Fatal(err)
+	}
+	if v := repo.GetByName("foo"); v != nil {
+		t.Fatalf("expected volume foo to not exist")
+	}
+}
+
+func TestRepositoryCreateCleanup(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.FindOrCreateNamedVolume("foo", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countEntries := func(dir string) int {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	dataDir := filepath.Dir(v.Path)
+	configs, volumes := countEntries(repo.configPath), countEntries(dataDir)
+
+	// Bypass the name lookup so that registering the volume collides
+	if _, err := repo.newVolume("foo", "", true, nil); err == nil {
+		t.Fatalf("expected registering a duplicate name to fail")
+	}
+	if n := countEntries(repo.configPath); n != configs {
+		t.Fatalf("expected failed volume config to be removed, found %d entries instead of %d", n, configs)
+	}
+	if n := countEntries(dataDir); n != volumes {
+		t.Fatalf("expected failed volume data to be removed, found %d entries instead of %d", n, volumes)
+	}
+}
+
+func TestRepositoryIsConfigPath(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(root, "repo-config")
+	for _, path := range []string{configPath, configPath + "/", filepath.Join(configPath, "1234")} {
+		if !repo.IsConfigPath(path) {
+			t.Fatalf("expected %s to be in the config path", path)
+		}
+	}
+	for _, path := range []string{root, configPath + "-other", filepath.Join(root, "repo-graph")} {
+		if repo.IsConfigPath(path) {
+			t.Fatalf("expected %s to not be in the config path", path)
+		}
+	}
+
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(configPath, link); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.IsConfigPath(link) {
+		t.Fatalf("expected a symlink to the config path to be detected")
+	}
+}
+
+func TestRepositoryGenerateID(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := strings.Repeat("ab", 32)
+	ids := []string{"", "../../etc", strings.ToUpper(valid), existing.ID, valid}
+	var generated int
+	repo.generateID = func() string {
+		id := ids[generated]
+		generated++
+		return id
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != valid || generated != len(ids) {
+		t.Fatalf("expected invalid and used IDs to be skipped, got %q after %d attempts", v.ID, generated)
+	}
+
+	repo.generateID = func() string { return "" }
+	if _, err := repo.FindOrCreateVolume("", true, nil); err == nil {
+		t.Fatalf("expected volume creation to fail without a valid ID")
+	}
+}
+
+func TestRepositoryList(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := repo.FindOrCreateVolume(filepath.Join(root, "bind"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumes := repo.List()
+	if len(volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(volumes))
+	}
+	found := make(map[string]*Volume)
+	for _, v := range volumes {
+		found[v.ID] = v
+	}
+	if found[v1.ID] != v1 || found[v2.ID] != v2 {
+		t.Fatalf("expected list to contain both volumes")
+	}
+
+	if name := v1.DriverName(); name != "vfs" {
+		t.Fatalf("expected driver vfs, got %q", name)
+	}
+	if name := v2.DriverName(); name != "" {
+		t.Fatalf("expected no driver for a bind mount, got %q", name)
+	}
+}
+
+func TestRepositoryFilter(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unused, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used.AddContainer("1234")
+	if _, err := repo.FindOrCreateVolume(filepath.Join(root, "bind"), true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if volumes := repo.Filter("", nil); len(volumes) != 3 {
+		t.Fatalf("expected 3 volumes, got %d", len(volumes))
+	}
+	if volumes := repo.Filter("vfs", nil); len(volumes) != 2 {
+		t.Fatalf("expected 2 vfs volumes, got %d", len(volumes))
+	}
+
+	inUse := false
+	volumes := repo.Filter("vfs", &inUse)
+	if len(volumes) != 1 || volumes[0] != unused {
+		t.Fatalf("expected only the unused vfs volume, got %v", volumes)
+	}
+	inUse = true
+	volumes = repo.Filter("", &inUse)
+	if len(volumes) != 1 || volumes[0] != used {
+		t.Fatalf("expected only the used volume, got %v", volumes)
+	}
+}
+
 func TestRepositoryDelete(t *testing.T) {
 	root, err := ioutil.TempDir(os.TempDir(), "volumes")
 	if err != nil {
@@ -97,7 +439,7 @@ func TestRepositoryDelete(t *testing.T) {
 	}
 
 	// with a normal volume
-	v, err := repo.FindOrCreateVolume("", true)
+	v, err := repo.FindOrCreateVolume("", true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,7 +458,7 @@ func TestRepositoryDelete(t *testing.T) {
 
 	// with a bind mount
 	dir := filepath.Join(root, "test")
-	v, err = repo.FindOrCreateVolume(dir, true)
+	v, err = repo.FindOrCreateVolume(dir, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -135,7 +477,7 @@ func TestRepositoryDelete(t *testing.T) {
 
 	// with container refs
 	dir = filepath.Join(root, "test")
-	v, err = repo.FindOrCreateVolume(dir, true)
+	v, err = repo.FindOrCreateVolume(dir, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,6 +494,577 @@ func TestRepositoryDelete(t *testing.T) {
 
 }
 
+func TestRepositoryLabels(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"backup": "true", "owner": "team-x"}
+	v, err := repo.FindOrCreateVolume("", true, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// labels must survive a restore from disk
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = repo.Get(v.Path)
+	if v == nil {
+		t.Fatalf("expected to find volume but didn't")
+	}
+	if !reflect.DeepEqual(v.Labels, labels) {
+		t.Fatalf("expected labels %v, got %v", labels, v.Labels)
+	}
+}
+
+func TestRepositoryPrune(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return clock }
+
+	unused, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used.AddContainer("1234")
+	bind, err := repo.FindOrCreateVolume(filepath.Join(root, "bind"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(pruneGracePeriod)
+	fresh, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := repo.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != unused.ID {
+		t.Fatalf("expected only %s to be pruned, got %v", unused.ID, removed)
+	}
+	if v := repo.Get(unused.Path); v != nil {
+		t.Fatalf("expected pruned volume to not exist")
+	}
+	if v := repo.Get(used.Path); v == nil {
+		t.Fatalf("expected volume in use to be kept")
+	}
+	if v := repo.Get(bind.Path); v == nil {
+		t.Fatalf("expected bind mount to be kept")
+	}
+	if v := repo.Get(fresh.Path); v == nil {
+		t.Fatalf("expected volume created within the grace period to be kept")
+	}
+	if _, err := os.Stat(bind.Path); err != nil {
+		t.Fatalf("expected bind mount data to be kept: %v", err)
+	}
+}
+
+func TestRepositoryTimestamps(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.CreatedAt.IsZero() {
+		t.Fatalf("expected creation time to be set")
+	}
+	if !v.LastUsedAt.IsZero() {
+		t.Fatalf("expected a new volume to never have been used")
+	}
+
+	v.AddContainer("1234")
+	if v.LastUsedAt.Before(v.CreatedAt) {
+		t.Fatalf("expected last used time to be updated when adding a container")
+	}
+	if err := v.ToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := repo.Get(v.Path)
+	if restored == nil {
+		t.Fatalf("expected to find volume but didn't")
+	}
+	if !restored.CreatedAt.Equal(v.CreatedAt) || !restored.LastUsedAt.Equal(v.LastUsedAt) {
+		t.Fatalf("expected timestamps to survive a restore, got %v/%v", restored.CreatedAt, restored.LastUsedAt)
+	}
+}
+
+// slowDriver delays volume creation to simulate a slow backing store
+type slowDriver struct {
+	graphdriver.Driver
+	delay time.Duration
+}
+
+func (d *slowDriver) Create(id, parent string) error {
+	time.Sleep(d.delay)
+	return d.Driver.Create(id, parent)
+}
+
+func TestRepositoryTouch(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return clock }
+
+	touched, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(pruneGracePeriod + time.Minute)
+	if err := repo.Touch(touched.Path); err != nil {
+		t.Fatal(err)
+	}
+	if !touched.LastUsedAt.After(touched.CreatedAt) {
+		t.Fatalf("expected touch to advance the last used time")
+	}
+	if len(touched.Containers()) != 0 {
+		t.Fatalf("expected touch not to add a container reference")
+	}
+
+	removed, err := repo.PruneOlderThan(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != stale.ID {
+		t.Fatalf("expected only %s to be pruned, got %v", stale.ID, removed)
+	}
+	if v := repo.Get(touched.Path); v == nil {
+		t.Fatalf("expected touched volume to be kept")
+	}
+}
+
+func TestRepositoryPruneOlderThanClock(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return clock }
+
+	old, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Hour)
+	recent, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Hour)
+	touched, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !touched.CreatedAt.Equal(clock) {
+		t.Fatalf("expected creation time %v, got %v", clock, touched.CreatedAt)
+	}
+	clock = clock.Add(3 * time.Hour)
+	if err := repo.Touch(touched.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	// old was last used 5h ago, recent 4h ago and touched just now
+	clock = clock.Add(time.Minute)
+	removed, err := repo.PruneOlderThan(4*time.Hour + 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != old.ID {
+		t.Fatalf("expected only %s to be pruned, got %v", old.ID, removed)
+	}
+
+	removed, err = repo.PruneOlderThan(4*time.Hour + time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != recent.ID {
+		t.Fatalf("expected only %s to be pruned, got %v", recent.ID, removed)
+	}
+	if v := repo.Get(touched.Path); v == nil {
+		t.Fatalf("expected touched volume to be kept")
+	}
+}
+
+func TestRepositoryClone(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := repo.FindOrCreateVolume("", false, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.AddContainer("1234")
+	if err := os.MkdirAll(filepath.Join(src.Path, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src.Path, "dir", "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := repo.Clone(src.ID[:12])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.ID == src.ID || clone.Path == src.Path {
+		t.Fatalf("expected clone to be a new volume")
+	}
+	if len(clone.Containers()) != 0 {
+		t.Fatalf("expected clone to not be used by any container, got %v", clone.Containers())
+	}
+	if clone.Writable || clone.Labels["a"] != "b" {
+		t.Fatalf("expected clone to keep mode and labels, got %+v", clone)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(clone.Path, "dir", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected cloned data to be hello, got %q", data)
+	}
+	if repo.Get(clone.Path) != clone {
+		t.Fatalf("expected clone to be registered")
+	}
+
+	if _, err := repo.Clone("missing"); err == nil {
+		t.Fatalf("expected cloning a missing volume to fail")
+	}
+}
+
+// snapshotDriver records the snapshots it is asked to take, which it
+// implements as plain copies
+type snapshotDriver struct {
+	graphdriver.Driver
+	snapshots []string
+}
+
+func (d *snapshotDriver) Snapshot(id, parent string) error {
+	d.snapshots = append(d.snapshots, parent)
+	return d.Driver.Create(id, parent)
+}
+
+func TestRepositorySnapshot(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	vfs, err := graphdriver.GetDriver("vfs", filepath.Join(root, "repo-graph"), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, driver := range []graphdriver.Driver{vfs, &snapshotDriver{Driver: vfs}} {
+		repo, err := NewRepository(filepath.Join(root, "repo-config"), driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := repo.FindOrCreateVolume("", true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(src.Path, "file"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		snap, err := repo.Snapshot(src.ID, "snap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if repo.GetByName("snap") != snap || snap.ID == src.ID {
+			t.Fatalf("expected snapshot to be a new volume named snap")
+		}
+		data, err := ioutil.ReadFile(filepath.Join(snap.Path, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello" {
+			t.Fatalf("expected snapshot data to be hello, got %q", data)
+		}
+		if d, ok := driver.(*snapshotDriver); ok && !reflect.DeepEqual(d.snapshots, []string{src.ID}) {
+			t.Fatalf("expected the driver to snapshot %s, got %v", src.ID, d.snapshots)
+		}
+
+		if _, err := repo.Snapshot(src.ID, "snap"); err == nil {
+			t.Fatalf("expected snapshot name to be unique")
+		}
+		if _, err := repo.Snapshot(src.ID, "a/b"); err == nil {
+			t.Fatalf("expected invalid snapshot name to be rejected")
+		}
+		if err := repo.Delete(snap.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepositoryConcurrentCreate(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	driver, err := graphdriver.GetDriver("vfs", filepath.Join(root, "repo-graph"), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay := 100 * time.Millisecond
+	repo, err := NewRepository(filepath.Join(root, "repo-config"), &slowDriver{driver, delay})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		n     = 50
+		wg    sync.WaitGroup
+		errs  = make(chan error, n)
+		start = time.Now()
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.FindOrCreateVolume("", true, nil); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// creations serialized behind a single lock would take n * delay
+	if elapsed := time.Since(start); elapsed > time.Duration(n)*delay/2 {
+		t.Fatalf("expected concurrent volume creations not to block each other, took %v", elapsed)
+	}
+	if len(repo.List()) != n {
+		t.Fatalf("expected %d volumes, got %d", n, len(repo.List()))
+	}
+
+	// concurrent requests for the same bind mount get the same volume
+	dir := filepath.Join(root, "bind")
+	binds := make(chan *Volume, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := repo.FindOrCreateVolume(dir, true, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			binds <- v
+		}()
+	}
+	wg.Wait()
+	close(binds)
+	first := <-binds
+	for v := range binds {
+		if v != first {
+			t.Fatalf("expected all requests for %s to return the same volume", dir)
+		}
+	}
+}
+
+func TestRepositoryRestoreContainers(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("1234")
+	v.AddContainer("5678")
+	v.RemoveContainer("5678")
+
+	// container references must survive a restart
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := repo.Get(v.Path)
+	if restored == nil {
+		t.Fatalf("expected to find volume but didn't")
+	}
+	if containers := restored.Containers(); !reflect.DeepEqual(containers, []string{"1234"}) {
+		t.Fatalf("expected containers [1234], got %v", containers)
+	}
+	if err := repo.Delete(v.Path); err == nil {
+		t.Fatalf("expected volume delete to fail due to restored container refs")
+	}
+}
+
+func TestRepositoryReconcileReferences(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("alive")
+	v.AddContainer("dead")
+
+	repo.ReconcileReferences([]string{"alive", "other"})
+	if containers := v.Containers(); !reflect.DeepEqual(containers, []string{"alive"}) {
+		t.Fatalf("expected containers [alive], got %v", containers)
+	}
+
+	// the stale reference must not come back after a restart
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containers := repo.Get(v.Path).Containers(); !reflect.DeepEqual(containers, []string{"alive"}) {
+		t.Fatalf("expected restored containers [alive], got %v", containers)
+	}
+}
+
+func TestRepositoryRestoreStrict(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "repo-config", v.ID, "config.json"), []byte("{corrupted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := graphdriver.GetDriver("vfs", filepath.Join(root, "repo-graph"), []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRepositoryStrict(filepath.Join(root, "repo-config"), driver); err == nil {
+		t.Fatalf("expected strict restore to fail on a corrupted volume config")
+	}
+
+	repo, err = newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := repo.FailedRestores(); len(failed) != 1 || failed[0] != v.ID {
+		t.Fatalf("expected %s to be reported as failed, got %v", v.ID, failed)
+	}
+}
+
+func TestRepositoryEvents(t *testing.T) {
+	root, err := ioutil.TempDir(os.TempDir(), "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo, err := newRepo(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	repo.SetEventHandler(func(action string, v *Volume, containerID string) {
+		events = append(events, action+" "+containerID)
+	})
+
+	v, err := repo.FindOrCreateVolume("", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("1234")
+	v.RemoveContainer("1234")
+	if err := repo.Delete(v.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"create ", "mount 1234", "unmount 1234", "destroy "}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+}
+
 func newRepo(root string) (*Repository, error) {
 	configPath := filepath.Join(root, "repo-config")
 	graphDir := filepath.Join(root, "repo-graph")
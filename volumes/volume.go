@@ -2,28 +2,75 @@ package volumes
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/symlink"
+	"github.com/docker/docker/vendor/src/code.google.com/p/go/src/pkg/archive/tar"
 )
 
 type Volume struct {
 	ID          string
+	Name        string
 	Path        string
 	IsBindMount bool
 	Writable    bool
+	Labels      map[string]string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
 	containers  map[string]struct{}
 	configPath  string
 	repository  *Repository
 	lock        sync.Mutex
 }
 
+// FileInfo describes a single entry of a volume manifest.
+type FileInfo struct {
+	Path    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// Manifest lists the files under resource within the volume, without reading
+// their contents. Paths are relative to the volume root.
+func (v *Volume) Manifest(resource string) ([]FileInfo, error) {
+	basePath, err := v.getResourcePath(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest []FileInfo
+	err = filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(v.Path, path)
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, FileInfo{
+			Path:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
 func (v *Volume) Export(resource, name string) (io.ReadCloser, error) {
 	if v.IsBindMount && filepath.Base(resource) == name {
 		name = ""
@@ -53,6 +100,79 @@ func (v *Volume) Export(resource, name string) (io.ReadCloser, error) {
 	})
 }
 
+// Backup writes a single tar archive of vols to w. Each volume is stored in a
+// directory named after its ID, holding its config.json and, under data/, the
+// content of the volume.
+func Backup(w io.Writer, vols []*Volume) error {
+	tw := tar.NewWriter(w)
+	for _, v := range vols {
+		if err := v.backup(tw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (v *Volume) backup(tw *tar.Writer) error {
+	jsonPath, err := v.jsonPath()
+	if err != nil {
+		return err
+	}
+	v.lock.Lock()
+	config, err := ioutil.ReadFile(jsonPath)
+	v.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    v.ID + "/config.json",
+		Mode:    0600,
+		Size:    int64(len(config)),
+		ModTime: time.Now().UTC(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(config); err != nil {
+		return err
+	}
+
+	data, err := v.Export("", "data")
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Name = v.ID + "/" + hdr.Name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = v.ID + "/" + hdr.Linkname
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// DriverName returns the name of the driver backing the volume. Bind mounts
+// are not managed by a driver so they have no driver name.
+func (v *Volume) DriverName() string {
+	if v.IsBindMount || v.repository == nil {
+		return ""
+	}
+	return v.repository.driver.String()
+}
+
 func (v *Volume) IsDir() (bool, error) {
 	stat, err := os.Stat(v.Path)
 	if err != nil {
@@ -77,13 +197,90 @@ func (v *Volume) Containers() []string {
 func (v *Volume) RemoveContainer(containerId string) {
 	v.lock.Lock()
 	delete(v.containers, containerId)
+	if err := v.toDisk(); err != nil {
+		log.Errorf("Error saving volume %s after removing container %s: %v", v.ID, containerId, err)
+	}
 	v.lock.Unlock()
+	v.logEvent("unmount", containerId)
 }
 
 func (v *Volume) AddContainer(containerId string) {
 	v.lock.Lock()
 	v.containers[containerId] = struct{}{}
+	v.LastUsedAt = v.now()
+	if err := v.toDisk(); err != nil {
+		log.Errorf("Error saving volume %s after adding container %s: %v", v.ID, containerId, err)
+	}
 	v.lock.Unlock()
+	v.logEvent("mount", containerId)
+}
+
+// RestoreContainer records a reference of containerId found when restoring
+// the container, without marking the volume as used now or logging a mount
+// event.
+func (v *Volume) RestoreContainer(containerId string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, exists := v.containers[containerId]; exists {
+		return
+	}
+	v.containers[containerId] = struct{}{}
+	if err := v.toDisk(); err != nil {
+		log.Errorf("Error saving volume %s after restoring container %s: %v", v.ID, containerId, err)
+	}
+}
+
+// Touch marks the volume as used now, without adding a container reference.
+func (v *Volume) Touch() error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.LastUsedAt = v.now()
+	return v.toDisk()
+}
+
+// Metadata is a consistent copy of the metadata of a volume which changes
+// while it is in use.
+type Metadata struct {
+	Labels     map[string]string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// Metadata returns a copy of the labels and timestamps of the volume, taken
+// under its lock so it can be read while containers use the volume.
+func (v *Volume) Metadata() Metadata {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return Metadata{
+		Labels:     copyLabels(v.Labels),
+		CreatedAt:  v.CreatedAt,
+		LastUsedAt: v.LastUsedAt,
+	}
+}
+
+// now returns the current time according to the repository's clock.
+func (v *Volume) now() time.Time {
+	if v.repository != nil {
+		return v.repository.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// lastUsed returns when the volume was last used, falling back to its
+// creation time if it never was.
+func (v *Volume) lastUsed() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.LastUsedAt.IsZero() {
+		return v.CreatedAt
+	}
+	return v.LastUsedAt
+}
+
+func (v *Volume) logEvent(action, containerId string) {
+	if v.repository != nil {
+		v.repository.logEvent(action, v, containerId)
+	}
 }
 
 func (v *Volume) initialize() error {
@@ -118,8 +315,19 @@ func (v *Volume) ToDisk() error {
 	return v.toDisk()
 }
 
+// volumeConfig is the on-disk representation of a volume, which also records
+// the containers using it so references survive a daemon restart.
+type volumeConfig struct {
+	*Volume
+	Containers []string
+}
+
 func (v *Volume) toDisk() error {
-	data, err := json.Marshal(v)
+	config := volumeConfig{Volume: v}
+	for c := range v.containers {
+		config.Containers = append(config.Containers, c)
+	}
+	data, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
@@ -129,7 +337,41 @@ func (v *Volume) toDisk() error {
 		return err
 	}
 
-	return ioutil.WriteFile(pth, data, 0666)
+	return writeFileAtomic(pth, data)
+}
+
+// writeFileAtomic replaces the file at pth with data, through a synced
+// temporary file in the same directory, so a crash while writing never leaves
+// a truncated file behind.
+func writeFileAtomic(pth string, data []byte) (err error) {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(pth), ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err := tmpFile.Chmod(0644); err != nil {
+		return err
+	}
+	n, err := tmpFile.Write(data)
+	if err != nil {
+		return err
+	}
+	if n < len(data) {
+		return io.ErrShortWrite
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), pth)
 }
 
 func (v *Volume) FromDisk() error {
@@ -146,20 +388,37 @@ func (v *Volume) FromDisk() error {
 	}
 	defer jsonSource.Close()
 
-	dec := json.NewDecoder(jsonSource)
-
-	return dec.Decode(v)
+	config := volumeConfig{Volume: v}
+	if err := json.NewDecoder(jsonSource).Decode(&config); err != nil {
+		return err
+	}
+	if v.containers == nil {
+		v.containers = make(map[string]struct{})
+	}
+	for _, c := range config.Containers {
+		v.containers[c] = struct{}{}
+	}
+	return nil
 }
 
 func (v *Volume) jsonPath() (string, error) {
 	return v.getRootResourcePath("config.json")
 }
 func (v *Volume) getRootResourcePath(path string) (string, error) {
-	cleanPath := filepath.Join("/", path)
-	return symlink.FollowSymlinkInScope(filepath.Join(v.configPath, cleanPath), v.configPath)
+	return resolveInScope(path, v.configPath)
 }
 
 func (v *Volume) getResourcePath(path string) (string, error) {
-	cleanPath := filepath.Join("/", path)
-	return symlink.FollowSymlinkInScope(filepath.Join(v.Path, cleanPath), v.Path)
+	return resolveInScope(path, v.Path)
+}
+
+// resolveInScope returns the path of resource in the directory scope,
+// following symlinks without leaving scope.
+func resolveInScope(resource, scope string) (string, error) {
+	cleanPath := filepath.Join("/", resource)
+	path, err := symlink.FollowSymlinkInScope(filepath.Join(scope, cleanPath), scope)
+	if err != nil {
+		return "", fmt.Errorf("Cannot resolve %s in %s: %v", cleanPath, scope, err)
+	}
+	return path, nil
 }
@@ -1,6 +1,14 @@
 package volumes
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/archive"
+)
 
 func TestContainers(t *testing.T) {
 	v := &Volume{containers: make(map[string]struct{})}
@@ -17,3 +25,173 @@ func TestContainers(t *testing.T) {
 		t.Fatalf("removing container failed")
 	}
 }
+
+func TestMetadata(t *testing.T) {
+	v := &Volume{
+		Labels:     map[string]string{"foo": "bar"},
+		containers: make(map[string]struct{}),
+	}
+	v.AddContainer("1234")
+
+	meta := v.Metadata()
+	if meta.LastUsedAt.IsZero() || meta.Labels["foo"] != "bar" {
+		t.Fatalf("expected the labels and last use of the volume, got %+v", meta)
+	}
+	meta.Labels["foo"] = "baz"
+	if v.Labels["foo"] != "bar" {
+		t.Fatal("expected the labels to be copied")
+	}
+}
+
+func TestToDiskReplacesConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	v := &Volume{
+		ID:         "1234",
+		Path:       filepath.Join(root, "vol"),
+		configPath: filepath.Join(root, "config"),
+		containers: make(map[string]struct{}),
+	}
+	if err := v.initialize(); err != nil {
+		t.Fatal(err)
+	}
+	v.AddContainer("abcd")
+
+	entries, err := ioutil.ReadDir(v.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "config.json" {
+		t.Fatalf("expected only config.json to be left in the config directory, got %v", entries)
+	}
+	if mode := entries[0].Mode().Perm(); mode != 0644 {
+		t.Fatalf("expected config.json to be 0644, got %v", mode)
+	}
+
+	restored := &Volume{configPath: v.configPath}
+	if err := restored.FromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if containers := restored.Containers(); len(containers) != 1 || containers[0] != "abcd" {
+		t.Fatalf("expected the container reference to be saved, got %v", containers)
+	}
+}
+
+func TestExportLongPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	v := &Volume{Path: filepath.Join(root, "vol")}
+	// well past the 100 byte name field of a plain ustar header
+	longDir := filepath.Join(strings.Repeat("a", 60), strings.Repeat("b", 60), strings.Repeat("c", 60))
+	if err := os.MkdirAll(filepath.Join(v.Path, longDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	longFile := filepath.Join(longDir, strings.Repeat("d", 80))
+	if err := ioutil.WriteFile(filepath.Join(v.Path, longFile), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tarball, err := v.Export("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarball.Close()
+
+	dest := filepath.Join(root, "dest")
+	if err := archive.Untar(tarball, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "vol", longFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("expected exported file contents to round-trip, got %q", data)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	root, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	v := &Volume{Path: root}
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"foo":     "hello",
+		"dir/bar": "hello world",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest, err := v.Manifest("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]FileInfo)
+	for _, fi := range manifest {
+		found[fi.Path] = fi
+	}
+	for name, data := range files {
+		fi, exists := found[name]
+		if !exists {
+			t.Fatalf("expected %s in manifest %v", name, manifest)
+		}
+		if fi.Size != int64(len(data)) {
+			t.Fatalf("expected %s to have size %d, got %d", name, len(data), fi.Size)
+		}
+	}
+	if fi, exists := found["dir"]; !exists || !fi.Mode.IsDir() {
+		t.Fatalf("expected dir in manifest as a directory")
+	}
+
+	// scoped to a sub directory
+	manifest, err = v.Manifest("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 2 {
+		t.Fatalf("expected dir and dir/bar in manifest, got %v", manifest)
+	}
+}
+
+func TestResourcePathError(t *testing.T) {
+	root, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	v := &Volume{Path: root}
+	// a symlink loop can't be resolved
+	if err := os.Symlink("b", filepath.Join(root, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a", filepath.Join(root, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = v.Export("a/file", "")
+	if err == nil {
+		t.Fatal("expected exporting through a symlink loop to fail")
+	}
+	if !strings.Contains(err.Error(), "/a/file") || !strings.Contains(err.Error(), root) {
+		t.Fatalf("expected error to name the resource and the volume path, got %v", err)
+	}
+}